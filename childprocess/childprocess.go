@@ -0,0 +1,293 @@
+/*
+Package childprocess provides a ShutdownCallback that stops child
+processes when shutdown is requested. It forwards a signal to every
+registered process, waits up to Timeout for them to exit and kills the
+ones that are still running.
+
+Processes started with exec.Cmd are waited for by the Forwarder, so do
+not call Wait on them yourself, use Forwarder.Wait instead:
+
+	forwarder := childprocess.NewForwarder(&childprocess.ForwarderConfig{
+		Timeout: time.Second * 30,
+	})
+	gs.AddShutdownCallback(forwarder)
+
+	cmd := exec.Command("ffmpeg", args...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	forwarder.AddCmd(cmd)
+
+	err := forwarder.Wait(cmd)
+
+Exited processes are removed from the Forwarder, so it does not grow in
+long running services and never signals a process ID that was reused.
+Call Wait once for every command added with AddCmd, like exec.Cmd.Wait.
+In ProcessGroup mode a command is removed only when no process is left
+in its group. Until then the group ID can not be reused.
+*/
+package childprocess
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultTimeout      = time.Second * 10
+	defaultPollInterval = time.Millisecond * 100
+)
+
+// ErrNotAdded is returned from Wait for commands that were not added
+// with AddCmd, or were already waited for.
+var ErrNotAdded = errors.New("childprocess: command was not added")
+
+var (
+	errUnsupportedSignal = errors.New("unsupported signal")
+	errGroupUnsupported  = errors.New("process groups are not supported on this platform")
+)
+
+// Forwarder implements ShutdownCallback interface that is added
+// to GracefulShutdown. Initialize with NewForwarder.
+type Forwarder struct {
+	config *ForwarderConfig
+
+	mutex    sync.Mutex
+	children []*child
+	cmds     map[*exec.Cmd]*child
+}
+
+type child struct {
+	cmd  *exec.Cmd
+	pgid int
+	done chan struct{}
+	err  error
+}
+
+// ForwarderConfig provides configuration options for Forwarder.
+type ForwarderConfig struct {
+	// Signal is forwarded to child processes on shutdown. Default is SIGTERM.
+	Signal os.Signal
+
+	// Timeout is time child processes have to exit after Signal, before
+	// they are killed. Default is 10 seconds.
+	Timeout time.Duration
+
+	// ProcessGroup sends signals to the whole process group of commands
+	// added with AddCmd instead of only the command. The command has to
+	// be started in its own process group, with Setpgid in SysProcAttr.
+	ProcessGroup bool
+
+	// PollInterval is period for checking if process groups have exited.
+	// Default is 100 milliseconds.
+	PollInterval time.Duration
+}
+
+func (fc *ForwarderConfig) clean() {
+	if fc.Signal == nil {
+		fc.Signal = syscall.SIGTERM
+	}
+	if fc.Timeout == 0 {
+		fc.Timeout = defaultTimeout
+	}
+	if fc.PollInterval == 0 {
+		fc.PollInterval = defaultPollInterval
+	}
+}
+
+// NewForwarder initializes the Forwarder. See ForwarderConfig for
+// configuration options.
+func NewForwarder(forwarderConfig *ForwarderConfig) *Forwarder {
+	if forwarderConfig == nil {
+		forwarderConfig = &ForwarderConfig{}
+	}
+	forwarderConfig.clean()
+	return &Forwarder{
+		config: forwarderConfig,
+		cmds:   make(map[*exec.Cmd]*child),
+	}
+}
+
+// AddCmd adds a started command. Forwarder waits for the command, so
+// use Wait to get its result.
+func (forwarder *Forwarder) AddCmd(cmd *exec.Cmd) {
+	c := &child{
+		cmd:  cmd,
+		done: make(chan struct{}),
+	}
+
+	forwarder.mutex.Lock()
+	forwarder.add(c)
+	forwarder.cmds[cmd] = c
+	forwarder.mutex.Unlock()
+
+	go func() {
+		c.err = cmd.Wait()
+		close(c.done)
+
+		if forwarder.exited(c) {
+			forwarder.remove(c)
+		}
+	}()
+}
+
+// AddProcessGroup adds a process group that was not started with
+// exec.Cmd. It is considered exited when no process is left in it.
+func (forwarder *Forwarder) AddProcessGroup(pgid int) {
+	forwarder.mutex.Lock()
+	defer forwarder.mutex.Unlock()
+	forwarder.add(&child{
+		pgid: pgid,
+	})
+}
+
+// add adds the child and removes children that exited in the meantime,
+// like process groups whose leader was already reaped. Caller must hold
+// the mutex.
+func (forwarder *Forwarder) add(c *child) {
+	children := make([]*child, 0, len(forwarder.children)+1)
+	for _, ch := range forwarder.children {
+		if !forwarder.exited(ch) {
+			children = append(children, ch)
+		}
+	}
+	forwarder.children = append(children, c)
+}
+
+// remove removes an exited child, so it is never signalled again.
+// A new slice is made, because OnShutdown may be iterating the old one.
+func (forwarder *Forwarder) remove(c *child) {
+	forwarder.mutex.Lock()
+	defer forwarder.mutex.Unlock()
+
+	children := make([]*child, 0, len(forwarder.children))
+	for _, ch := range forwarder.children {
+		if ch != c {
+			children = append(children, ch)
+		}
+	}
+	forwarder.children = children
+}
+
+// Wait waits for a command added with AddCmd to exit and returns
+// the error from exec.Cmd.Wait.
+func (forwarder *Forwarder) Wait(cmd *exec.Cmd) error {
+	forwarder.mutex.Lock()
+	c, ok := forwarder.cmds[cmd]
+	delete(forwarder.cmds, cmd)
+	forwarder.mutex.Unlock()
+
+	if !ok {
+		return ErrNotAdded
+	}
+	<-c.done
+	return c.err
+}
+
+// OnShutdown forwards Signal to all child processes, waits for them to
+// exit and kills them after Timeout. Returns an error if any process
+// had to be killed.
+func (forwarder *Forwarder) OnShutdown(shutdownManager string) error {
+	forwarder.mutex.Lock()
+	children := forwarder.children
+	forwarder.mutex.Unlock()
+
+	var errs []error
+	for _, c := range children {
+		if err := forwarder.signal(c, forwarder.config.Signal); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	running := forwarder.waitExit(children, time.Now().Add(forwarder.config.Timeout))
+	for _, c := range running {
+		if err := forwarder.signal(c, syscall.SIGKILL); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, c := range running {
+		if c.cmd != nil {
+			<-c.done
+		}
+	}
+
+	if len(running) > 0 {
+		errs = append(errs, fmt.Errorf("childprocess: killed %d processes after %s", len(running), forwarder.config.Timeout))
+	}
+	return errors.Join(errs...)
+}
+
+// waitExit waits until all children exit or deadline passes and
+// returns children that are still running.
+func (forwarder *Forwarder) waitExit(children []*child, deadline time.Time) []*child {
+	ticker := time.NewTicker(forwarder.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		var running []*child
+		for _, c := range children {
+			if forwarder.exited(c) {
+				forwarder.remove(c)
+			} else {
+				running = append(running, c)
+			}
+		}
+		if len(running) == 0 || !time.Now().Before(deadline) {
+			return running
+		}
+		children = running
+
+		select {
+		case <-ticker.C:
+		case <-running[0].done:
+		}
+	}
+}
+
+func (forwarder *Forwarder) signal(c *child, sig os.Signal) error {
+	if forwarder.exited(c) {
+		forwarder.remove(c)
+		return nil
+	}
+
+	var err error
+	switch {
+	case c.cmd == nil:
+		err = signalGroup(c.pgid, sig)
+	case forwarder.config.ProcessGroup:
+		err = signalGroup(c.cmd.Process.Pid, sig)
+	default:
+		err = c.cmd.Process.Signal(sig)
+	}
+
+	if err == nil || errors.Is(err, os.ErrProcessDone) || errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return fmt.Errorf("childprocess: %s: %v", c, err)
+}
+
+// exited returns true if the process, or all processes of the group
+// when signalling process groups, have exited.
+func (forwarder *Forwarder) exited(c *child) bool {
+	if c.cmd == nil {
+		return !groupAlive(c.pgid)
+	}
+	select {
+	case <-c.done:
+		return !forwarder.config.ProcessGroup || !groupAlive(c.cmd.Process.Pid)
+	default:
+		return false
+	}
+}
+
+func (c *child) String() string {
+	if c.cmd == nil {
+		return fmt.Sprintf("process group %d", c.pgid)
+	}
+	return fmt.Sprintf("process %d", c.cmd.Process.Pid)
+}
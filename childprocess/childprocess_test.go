@@ -0,0 +1,135 @@
+//go:build !windows
+// +build !windows
+
+package childprocess
+
+import (
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func startCmd(t *testing.T, script string, setpgid bool) *exec.Cmd {
+	cmd := exec.Command("sh", "-c", script)
+	if setpgid {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal("Start:", err)
+	}
+	return cmd
+}
+
+func TestForwardSignal(t *testing.T) {
+	forwarder := NewForwarder(&ForwarderConfig{
+		Timeout:      time.Second * 5,
+		PollInterval: time.Millisecond,
+	})
+	cmd := startCmd(t, "sleep 10", false)
+	forwarder.AddCmd(cmd)
+
+	start := time.Now()
+	if err := forwarder.OnShutdown("test"); err != nil {
+		t.Error("Expected no error, got", err)
+	}
+	if time.Since(start) > time.Second*2 {
+		t.Error("Expected process to exit on SIGTERM, took", time.Since(start))
+	}
+
+	err := forwarder.Wait(cmd)
+	if err == nil || !strings.Contains(err.Error(), "terminated") {
+		t.Error("Expected process terminated by signal, got", err)
+	}
+}
+
+func TestKillAfterTimeout(t *testing.T) {
+	forwarder := NewForwarder(&ForwarderConfig{
+		Timeout:      time.Millisecond * 100,
+		PollInterval: time.Millisecond,
+	})
+	cmd := startCmd(t, "trap '' TERM; sleep 10", false)
+	forwarder.AddCmd(cmd)
+
+	time.Sleep(time.Millisecond * 50)
+
+	err := forwarder.OnShutdown("test")
+	if err == nil || !strings.Contains(err.Error(), "killed 1 processes") {
+		t.Error("Expected killed error, got", err)
+	}
+
+	err = forwarder.Wait(cmd)
+	if err == nil || !strings.Contains(err.Error(), "killed") {
+		t.Error("Expected process killed, got", err)
+	}
+}
+
+func TestProcessGroup(t *testing.T) {
+	forwarder := NewForwarder(&ForwarderConfig{
+		Timeout:      time.Second * 5,
+		PollInterval: time.Millisecond,
+	})
+	cmd := startCmd(t, "sleep 10 & sleep 10 & wait", true)
+	pgid := cmd.Process.Pid
+	forwarder.AddProcessGroup(pgid)
+
+	time.Sleep(time.Millisecond * 50)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	if err := forwarder.OnShutdown("test"); err != nil {
+		t.Error("Expected no error, got", err)
+	}
+
+	if groupAlive(pgid) {
+		t.Error("Expected process group to exit.")
+	}
+	<-done
+}
+
+func TestWaitNotAdded(t *testing.T) {
+	forwarder := NewForwarder(nil)
+	if err := forwarder.Wait(exec.Command("true")); err != ErrNotAdded {
+		t.Error("Expected ErrNotAdded, got", err)
+	}
+}
+
+func TestExitedRemoved(t *testing.T) {
+	forwarder := NewForwarder(&ForwarderConfig{
+		ProcessGroup: true,
+	})
+
+	cmd := startCmd(t, "true", true)
+	forwarder.AddCmd(cmd)
+	if err := forwarder.Wait(cmd); err != nil {
+		t.Error("Expected no error, got", err)
+	}
+	if err := forwarder.Wait(cmd); err != ErrNotAdded {
+		t.Error("Expected ErrNotAdded on repeated Wait, got", err)
+	}
+
+	group := startCmd(t, "exec sleep 10", true)
+	forwarder.AddProcessGroup(group.Process.Pid)
+
+	forwarder.mutex.Lock()
+	count := len(forwarder.children)
+	forwarder.mutex.Unlock()
+	if count != 1 {
+		t.Error("Expected only the live process group to be kept, got", count)
+	}
+
+	group.Process.Kill()
+	group.Wait()
+	forwarder.AddProcessGroup(1 << 30)
+
+	forwarder.mutex.Lock()
+	count = len(forwarder.children)
+	forwarder.mutex.Unlock()
+	if count != 1 {
+		t.Error("Expected exited process group to be removed, got", count)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package childprocess
+
+import (
+	"os"
+	"syscall"
+)
+
+func signalGroup(pgid int, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return errUnsupportedSignal
+	}
+	return syscall.Kill(-pgid, s)
+}
+
+// groupAlive returns true if any process is left in the process group.
+func groupAlive(pgid int) bool {
+	return syscall.Kill(-pgid, 0) != syscall.ESRCH
+}
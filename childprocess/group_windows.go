@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package childprocess
+
+import (
+	"os"
+)
+
+func signalGroup(pgid int, sig os.Signal) error {
+	return errGroupUnsupported
+}
+
+func groupAlive(pgid int) bool {
+	return false
+}
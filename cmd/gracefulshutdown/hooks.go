@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// hooks are run on shutdown before the signal is forwarded to the child.
+type hooks struct {
+	urls     []string
+	commands []string
+	timeout  time.Duration
+}
+
+// run runs all hooks in order. A failed hook does not stop the others,
+// the first error is returned.
+func (h *hooks) run(ctx context.Context) error {
+	var first error
+	for _, url := range h.urls {
+		if err := h.post(ctx, url); err != nil && first == nil {
+			first = err
+		}
+	}
+	for _, command := range h.commands {
+		if err := h.exec(ctx, command); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (h *hooks) post(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return fmt.Errorf("pre-drain hook %s: %v", url, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("pre-drain hook %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("pre-drain hook %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+func (h *hooks) exec(ctx context.Context, command string) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pre-drain hook %q: %v", command, err)
+	}
+	return nil
+}
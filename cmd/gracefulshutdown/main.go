@@ -0,0 +1,187 @@
+/*
+Command gracefulshutdown runs a child command and attaches shutdown
+managers to it, so processes not written in Go get the same shutdown
+handling.
+
+	gracefulshutdown [flags] -- command [args...]
+
+Shutdown is started by SIGINT or SIGTERM, by an AWS autoscaling
+lifecycle hook if -sqs-queue or -aws-port is set, or by touching the
+sentinel file given with -sentinel. On shutdown, pre-drain hooks are run
+in order: HTTP POST requests given with -pre-drain-http and shell
+commands given with -pre-drain-exec. Then -signal is forwarded to the
+child, which is killed if it does not exit in -timeout. Only after the
+child exited is the AWS lifecycle action completed.
+
+The exit code is the exit code of the child, or 128 plus the signal
+number if the child was terminated by a signal.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/childprocess"
+	"github.com/Zemanta/gracefulshutdown/shutdownmanagers/awsmanager"
+	"github.com/Zemanta/gracefulshutdown/shutdownmanagers/filemanager"
+	"github.com/Zemanta/gracefulshutdown/shutdownmanagers/posixsignal"
+)
+
+func main() {
+	var (
+		sig           = flag.String("signal", "TERM", "signal forwarded to the child on shutdown")
+		timeout       = flag.Duration("timeout", time.Second*30, "time the child has to exit before it is killed")
+		hookTimeout   = flag.Duration("hook-timeout", time.Second*30, "time each pre-drain hook can take")
+		sentinel      = flag.String("sentinel", "", "start shutdown when this file is created or touched")
+		sqsQueue      = flag.String("sqs-queue", "", "sqs queue with autoscaling lifecycle messages")
+		lifecycleHook = flag.String("lifecycle-hook", "", "name of the autoscaling lifecycle hook")
+		awsPort       = flag.Uint("aws-port", 0, "port for forwarded lifecycle messages, 0 disables it")
+		preDrainHTTP  stringsFlag
+		preDrainExec  stringsFlag
+		managers      []gracefulshutdown.ShutdownManager
+		forwardSignal os.Signal
+		err           error
+	)
+	flag.Var(&preDrainHTTP, "pre-drain-http", "URL to POST to before the signal is forwarded, can be repeated")
+	flag.Var(&preDrainExec, "pre-drain-exec", "shell command to run before the signal is forwarded, can be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] -- command [args...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetPrefix("gracefulshutdown: ")
+	log.SetFlags(0)
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if forwardSignal, err = parseSignal(*sig); err != nil {
+		log.Fatal(err)
+	}
+
+	managers = append(managers, posixsignal.NewPosixSignalManagerWithConfig(&posixsignal.PosixSignalManagerConfig{
+		NoExit: true,
+	}))
+	if *sentinel != "" {
+		managers = append(managers, filemanager.NewFileManager(&filemanager.FileManagerConfig{
+			Path: *sentinel,
+		}))
+	}
+	if *sqsQueue != "" || *awsPort != 0 {
+		managers = append(managers, awsmanager.NewAwsManager(&awsmanager.AwsManagerConfig{
+			SqsQueueName:      *sqsQueue,
+			LifecycleHookName: *lifecycleHook,
+			Port:              uint16(*awsPort),
+		}))
+	}
+
+	code, err := run(flag.Args(), managers, &childprocess.ForwarderConfig{
+		Signal:  forwardSignal,
+		Timeout: *timeout,
+	}, &hooks{
+		urls:     preDrainHTTP,
+		commands: preDrainExec,
+		timeout:  *hookTimeout,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(code)
+}
+
+// run starts the command with managers attached and returns its exit
+// code once it exited and shutdown, if requested, finished.
+func run(args []string, managers []gracefulshutdown.ShutdownManager, forwarderConfig *childprocess.ForwarderConfig, hooks *hooks) (int, error) {
+	gs := gracefulshutdown.New()
+	gs.SetErrorHandler(gracefulshutdown.ErrorFunc(func(err error) {
+		log.Println(err)
+	}))
+
+	forwarder := childprocess.NewForwarder(forwarderConfig)
+	gs.AddShutdownCallback(gracefulshutdown.ShutdownFunc(func(shutdownManager string) error {
+		log.Println("shutdown requested by", shutdownManager)
+		err := hooks.run(context.Background())
+		return errors.Join(err, forwarder.OnShutdown(shutdownManager))
+	}))
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	forwarder.AddCmd(cmd)
+
+	for _, manager := range managers {
+		gs.AddShutdownManager(manager)
+	}
+	if err := gs.Start(); err != nil {
+		cmd.Process.Kill()
+		forwarder.Wait(cmd)
+		return 0, err
+	}
+
+	err := forwarder.Wait(cmd)
+	if gs.Context().Err() != nil {
+		<-gs.Done()
+	}
+
+	var childExitError *exec.ExitError
+	if errors.As(err, &childExitError) {
+		return exitCode(childExitError), nil
+	}
+	return 0, err
+}
+
+// exitCode returns exit code of the child, or 128 plus the signal number
+// if it was terminated by a signal.
+func exitCode(err *exec.ExitError) int {
+	if status, ok := err.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return err.ExitCode()
+}
+
+var signals = map[string]os.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
+
+// parseSignal parses a signal name with or without the SIG prefix.
+func parseSignal(name string) (os.Signal, error) {
+	if len(name) > 3 && name[:3] == "SIG" {
+		name = name[3:]
+	}
+	if sig, ok := signals[name]; ok {
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unknown signal %q", name)
+}
+
+// stringsFlag is a flag that can be repeated.
+type stringsFlag []string
+
+func (sf *stringsFlag) String() string {
+	return fmt.Sprint(*sf)
+}
+
+func (sf *stringsFlag) Set(value string) error {
+	*sf = append(*sf, value)
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/childprocess"
+)
+
+func TestParseSignal(t *testing.T) {
+	for name, expected := range map[string]syscall.Signal{
+		"TERM":   syscall.SIGTERM,
+		"SIGINT": syscall.SIGINT,
+	} {
+		sig, err := parseSignal(name)
+		if err != nil || sig != expected {
+			t.Error("Expected", expected, "for", name, "got", sig, err)
+		}
+	}
+
+	if _, err := parseSignal("NOPE"); err == nil {
+		t.Error("Expected error for unknown signal.")
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls = append(calls, req.Method+" "+req.URL.Path)
+		if req.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	h := &hooks{
+		urls:     []string{server.URL + "/fail", server.URL + "/drain"},
+		commands: []string{"exit 0"},
+		timeout:  time.Second,
+	}
+	err := h.run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Error("Expected 503 error, got", err)
+	}
+	if len(calls) != 2 || calls[1] != "POST /drain" {
+		t.Error("Expected both hooks to be called, got", calls)
+	}
+
+	h = &hooks{
+		commands: []string{"exit 3"},
+		timeout:  time.Second,
+	}
+	if err := h.run(context.Background()); err == nil {
+		t.Error("Expected error from failed command.")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	err := exec.Command("sh", "-c", "exit 3").Run()
+	if code := exitCode(err.(*exec.ExitError)); code != 3 {
+		t.Error("Expected exit code 3, got", code)
+	}
+
+	err = exec.Command("sh", "-c", "kill -TERM $$").Run()
+	if code := exitCode(err.(*exec.ExitError)); code != 143 {
+		t.Error("Expected exit code 143, got", code)
+	}
+}
+
+// fakeManager requests shutdown from the test and logs when it would
+// complete the AWS lifecycle action in ShutdownFinish.
+type fakeManager struct {
+	log     string
+	started chan gracefulshutdown.GSInterface
+}
+
+func (fm *fakeManager) GetName() string {
+	return "FakeManager"
+}
+
+func (fm *fakeManager) Start(gs gracefulshutdown.GSInterface) error {
+	fm.started <- gs
+	return nil
+}
+
+func (fm *fakeManager) ShutdownStart() error {
+	return nil
+}
+
+func (fm *fakeManager) ShutdownFinish() error {
+	return appendLine(fm.log, "complete")
+}
+
+func appendLine(path, line string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(line + "\n")
+	return err
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gracefulshutdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "log")
+
+	fm := &fakeManager{
+		log:     logPath,
+		started: make(chan gracefulshutdown.GSInterface, 1),
+	}
+
+	type result struct {
+		code int
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		code, err := run([]string{"sh", "-c", `trap 'echo term >> "$0"; exit 3' TERM; echo started >> "$0"; while :; do sleep 0.01; done`, logPath},
+			[]gracefulshutdown.ShutdownManager{fm},
+			&childprocess.ForwarderConfig{Timeout: time.Second * 5},
+			&hooks{
+				commands: []string{`echo hook >> "` + logPath + `"`},
+				timeout:  time.Second,
+			})
+		done <- result{code, err}
+	}()
+
+	gs := <-fm.started
+	for i := 0; i < 100; i++ {
+		if contents, _ := ioutil.ReadFile(logPath); len(contents) > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// two triggers must not run hooks and forwarding twice
+	go gs.StartShutdown(fm)
+	gs.StartShutdown(fm)
+
+	select {
+	case r := <-done:
+		if r.err != nil || r.code != 3 {
+			t.Error("Expected exit code 3, got", r.code, r.err)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("Timeout waiting for run.")
+	}
+
+	contents, _ := ioutil.ReadFile(logPath)
+	if lines := strings.Fields(string(contents)); strings.Join(lines, " ") != "started hook term complete" {
+		t.Error("Expected hook, signal and then lifecycle completion, got", lines)
+	}
+}
+
+func TestRunRollsBackManagers(t *testing.T) {
+	c := make(chan int, 100)
+	stopper := &stopManager{c: c}
+	failing := &stopManager{err: errors.New("start-error")}
+
+	_, err := run([]string{"sh", "-c", "exec sleep 10"},
+		[]gracefulshutdown.ShutdownManager{stopper, failing},
+		&childprocess.ForwarderConfig{Timeout: time.Second},
+		&hooks{timeout: time.Second})
+	if err == nil || err.Error() != "start-error" {
+		t.Error("Expected start-error, got", err)
+	}
+	if len(c) != 1 {
+		t.Error("Expected started manager to be stopped.")
+	}
+}
+
+type stopManager struct {
+	c   chan int
+	err error
+}
+
+func (sm *stopManager) GetName() string {
+	return "StopManager"
+}
+
+func (sm *stopManager) Start(gs gracefulshutdown.GSInterface) error {
+	return sm.err
+}
+
+func (sm *stopManager) Stop() error {
+	sm.c <- 1
+	return nil
+}
+
+func (sm *stopManager) ShutdownStart() error {
+	return nil
+}
+
+func (sm *stopManager) ShutdownFinish() error {
+	return nil
+}
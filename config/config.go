@@ -0,0 +1,491 @@
+/*
+Package config builds ShutdownManagers from a JSON or YAML file or
+environment variables, so they can be changed without rebuilding the
+application.
+
+Managers are created by constructors registered by name with Register.
+PosixSignalManager, AwsManager and FileManager are registered by this
+package. A JSON config lists managers with their configuration, and can
+set the global timeout, phases and exit policy of GracefulShutdown:
+
+	{
+		"timeout": "60s",
+		"phases": [
+			{"name": "drain", "timeout": "30s"},
+			{"name": "close"}
+		],
+		"exit": {"code": 0, "timeoutCode": 1},
+		"managers": [
+			{"name": "PosixSignalManager", "config": {"signals": ["SIGTERM"]}},
+			{"name": "AwsManager", "config": {
+				"sqsQueueName": "example-sqs-queue",
+				"lifecycleHookName": "example-lifecycle-hook",
+				"pingTime": "5m",
+				"port": 7999
+			}},
+			{"name": "FileManager", "config": {"path": "/run/app/drain"}}
+		]
+	}
+
+YAML has the same structure:
+
+	timeout: 60s
+	phases:
+	  - name: drain
+	    timeout: 30s
+	  - name: close
+	exit:
+	  code: 0
+	  timeoutCode: 1
+	managers:
+	  - name: PosixSignalManager
+	    config:
+	      signals: [SIGTERM]
+
+and is applied to GracefulShutdown with:
+
+	cfg, err := config.LoadFile("shutdown.yaml")
+	if err != nil {
+		return err
+	}
+	if err := cfg.Apply(gs); err != nil {
+		return err
+	}
+
+See GracefulShutdown.SetTimeout, SetPhase and SetExitPolicy for what
+timeout, phases and exit do. Shutdown callbacks are code and are still
+added with AddShutdownCallback, or with AddPhaseCallback to run in one
+of the configured phases. When exit is set, PosixSignalManager leaves
+exiting to the exit policy.
+
+The same configuration can be given in environment variables, see LoadEnv.
+Durations are strings parsed with time.ParseDuration. Unknown fields are
+an error, so typos are not silently ignored.
+*/
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"gopkg.in/yaml.v3"
+)
+
+// Constructor creates a ShutdownManager from its JSON configuration.
+// The configuration is nil if it was not given.
+type Constructor func(config json.RawMessage) (gracefulshutdown.ShutdownManager, error)
+
+var (
+	registryMutex sync.Mutex
+	registry      = make(map[string]Constructor)
+)
+
+// Register makes a ShutdownManager constructor available by name.
+// Register panics if it is called twice with the same name.
+func Register(name string, constructor Constructor) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic("config: Register called twice for " + name)
+	}
+	registry[name] = constructor
+}
+
+// Registered returns sorted names of registered constructors.
+func Registered() []string {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Config lists ShutdownManagers to add to GracefulShutdown, and its
+// global timeout, phases and exit policy.
+type Config struct {
+	// Timeout is passed to GracefulShutdown.SetTimeout if it is set.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// Phases are passed to GracefulShutdown.SetPhase in order.
+	Phases []PhaseConfig `json:"phases,omitempty"`
+
+	// Exit is passed to GracefulShutdown.SetExitPolicy if it is set.
+	Exit *ExitConfig `json:"exit,omitempty"`
+
+	Managers []ManagerConfig `json:"managers"`
+}
+
+// PhaseConfig is a phase of ShutdownCallbacks and its timeout.
+type PhaseConfig struct {
+	Name    string   `json:"name"`
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// ExitConfig is the exit policy of GracefulShutdown.
+type ExitConfig struct {
+	Code        int `json:"code"`
+	TimeoutCode int `json:"timeoutCode"`
+}
+
+// ManagerConfig is the name of a registered constructor and
+// the configuration passed to it.
+type ManagerConfig struct {
+	Name   string          `json:"name"`
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// Load reads JSON configuration from r.
+func Load(r io.Reader) (*Config, error) {
+	config := &Config{}
+	if err := decode(r, config); err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+	return config, nil
+}
+
+// LoadYAML reads YAML configuration from r. It has the same fields as
+// JSON configuration.
+func LoadYAML(r io.Reader) (*Config, error) {
+	var v interface{}
+	if err := yaml.NewDecoder(r).Decode(&v); err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+	return Load(bytes.NewReader(b))
+}
+
+// LoadFile reads configuration from a file, YAML if its extension is
+// .yaml or .yml, JSON otherwise.
+func LoadFile(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return LoadYAML(file)
+	}
+	return Load(file)
+}
+
+// LoadEnv reads configuration from environment variables. PREFIX_MANAGERS
+// is a comma separated list of manager names, and PREFIX_NAME_FIELD sets
+// a field of the manager configuration, with NAME and FIELD in upper case.
+// Values are decoded by the type of the field, see Unmarshal:
+//
+//	GS_MANAGERS=PosixSignalManager,AwsManager
+//	GS_POSIXSIGNALMANAGER_SIGNALS=SIGTERM,SIGINT
+//	GS_AWSMANAGER_SQSQUEUENAME=example-sqs-queue
+//	GS_AWSMANAGER_PINGTIME=5m
+//	GS_AWSMANAGER_PORT=7999
+//
+// PREFIX_TIMEOUT sets the global timeout, PREFIX_PHASES is a comma separated
+// list of phases with optional timeouts, and PREFIX_EXIT_CODE and
+// PREFIX_EXIT_TIMEOUTCODE set the exit policy:
+//
+//	GS_TIMEOUT=60s
+//	GS_PHASES=drain:30s,close
+//	GS_EXIT_CODE=0
+func LoadEnv(prefix string) (*Config, error) {
+	config := &Config{}
+
+	if timeout := os.Getenv(prefix + "_TIMEOUT"); timeout != "" {
+		duration, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("config: %s_TIMEOUT: %v", prefix, err)
+		}
+		config.Timeout = Duration(duration)
+	}
+
+	for _, phase := range splitList(os.Getenv(prefix + "_PHASES")) {
+		name, timeout, ok := strings.Cut(phase, ":")
+		phaseConfig := PhaseConfig{Name: strings.TrimSpace(name)}
+		if ok {
+			duration, err := time.ParseDuration(strings.TrimSpace(timeout))
+			if err != nil {
+				return nil, fmt.Errorf("config: %s_PHASES: %v", prefix, err)
+			}
+			phaseConfig.Timeout = Duration(duration)
+		}
+		config.Phases = append(config.Phases, phaseConfig)
+	}
+
+	exit := &ExitConfig{}
+	for _, field := range []struct {
+		name string
+		code *int
+	}{
+		{"CODE", &exit.Code},
+		{"TIMEOUTCODE", &exit.TimeoutCode},
+	} {
+		value := os.Getenv(prefix + "_EXIT_" + field.name)
+		if value == "" {
+			continue
+		}
+		code, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("config: %s_EXIT_%s: %v", prefix, field.name, err)
+		}
+		*field.code = code
+		config.Exit = exit
+	}
+
+	for _, name := range splitList(os.Getenv(prefix + "_MANAGERS")) {
+		fields := make(map[string]string)
+		fieldPrefix := prefix + "_" + strings.ToUpper(name) + "_"
+		for _, env := range os.Environ() {
+			key, value, _ := strings.Cut(env, "=")
+			if !strings.HasPrefix(key, fieldPrefix) {
+				continue
+			}
+			fields[strings.ToLower(strings.TrimPrefix(key, fieldPrefix))] = value
+		}
+
+		managerConfig := ManagerConfig{Name: name}
+		if len(fields) > 0 {
+			managerConfig.Config, _ = json.Marshal(fields)
+		}
+		config.Managers = append(config.Managers, managerConfig)
+	}
+
+	return config, nil
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Build creates all configured ShutdownManagers.
+func (config *Config) Build() ([]gracefulshutdown.ShutdownManager, error) {
+	managers := make([]gracefulshutdown.ShutdownManager, 0, len(config.Managers))
+	for _, managerConfig := range config.Managers {
+		registryMutex.Lock()
+		constructor, ok := registry[managerConfig.Name]
+		registryMutex.Unlock()
+
+		if !ok {
+			return nil, fmt.Errorf("config: unknown manager %q, registered are %s", managerConfig.Name, strings.Join(Registered(), ", "))
+		}
+
+		manager, err := constructor(managerConfig.Config)
+		if err != nil {
+			return nil, fmt.Errorf("config: %s: %v", managerConfig.Name, err)
+		}
+		managers = append(managers, manager)
+	}
+	return managers, nil
+}
+
+// Apply creates all configured ShutdownManagers and adds them to gs,
+// and sets its timeout, phases and exit policy if they are configured.
+// Nothing is changed if any of them fails.
+func (config *Config) Apply(gs *gracefulshutdown.GracefulShutdown) error {
+	phases := make(map[string]bool)
+	for _, phase := range config.Phases {
+		if phase.Name == "" {
+			return fmt.Errorf("config: phase without a name")
+		}
+		if phases[phase.Name] {
+			return fmt.Errorf("config: duplicate phase %q", phase.Name)
+		}
+		phases[phase.Name] = true
+	}
+
+	managers, err := config.Build()
+	if err != nil {
+		return err
+	}
+
+	if config.Timeout > 0 {
+		gs.SetTimeout(time.Duration(config.Timeout))
+	}
+	for _, phase := range config.Phases {
+		gs.SetPhase(gracefulshutdown.Phase(phase.Name), time.Duration(phase.Timeout))
+	}
+	if config.Exit != nil {
+		gs.SetExitPolicy(&gracefulshutdown.ExitPolicy{
+			Code:        config.Exit.Code,
+			TimeoutCode: config.Exit.TimeoutCode,
+		})
+	}
+	for _, manager := range managers {
+		gs.AddShutdownManager(manager)
+	}
+	return nil
+}
+
+// Unmarshal decodes a manager configuration into v, rejecting unknown
+// fields. It does nothing if config is empty, so v keeps its defaults.
+// It is meant for use in Constructors.
+//
+// If v is a struct, values are decoded by the type of its fields, so
+// values from environment variables, which are always strings, work:
+// "7999" for a number, "true" for a bool, and `["SIGTERM"]` or
+// "SIGTERM,SIGINT" for a list of strings. Numbers and bools, like an
+// unquoted 12345 in YAML, are accepted for string fields.
+func Unmarshal(config json.RawMessage, v interface{}) error {
+	if len(config) == 0 || string(config) == "null" {
+		return nil
+	}
+	return decode(bytes.NewReader(byFieldType(config, reflect.TypeOf(v))), v)
+}
+
+// byFieldType converts values in object config to the types of the
+// matching fields of struct t, where they are strings or scalars given
+// for strings.
+func byFieldType(config json.RawMessage, t reflect.Type) json.RawMessage {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return config
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(config, &values); err != nil {
+		return config
+	}
+
+	changed := false
+	for key, value := range values {
+		field, ok := fieldByName(t, key)
+		if !ok {
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			if raw, ok := toString(value, field.Type); ok {
+				values[key] = raw
+				changed = true
+			}
+			continue
+		}
+		if raw, ok := fromString(s, field.Type); ok {
+			values[key] = raw
+			changed = true
+		}
+	}
+
+	if !changed {
+		return config
+	}
+	config, _ = json.Marshal(values)
+	return config
+}
+
+var (
+	jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// plain dereferences t and returns false if it decodes itself.
+func plain(t reflect.Type) (reflect.Type, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(jsonUnmarshaler) || reflect.PointerTo(t).Implements(textUnmarshaler) {
+		return t, false
+	}
+	return t, true
+}
+
+// toString returns number or bool value as a JSON string if t is
+// a string.
+func toString(value json.RawMessage, t reflect.Type) (json.RawMessage, bool) {
+	t, ok := plain(t)
+	if !ok || t.Kind() != reflect.String {
+		return nil, false
+	}
+	switch value[0] {
+	case '{', '[', 'n':
+		return nil, false
+	}
+	raw, _ := json.Marshal(string(value))
+	return raw, true
+}
+
+// fromString returns JSON to decode s into type t, or false if t is
+// decoded from a string.
+func fromString(s string, t reflect.Type) (json.RawMessage, bool) {
+	t, ok := plain(t)
+	if !ok {
+		return nil, false
+	}
+
+	s = strings.TrimSpace(s)
+	switch t.Kind() {
+	case reflect.String, reflect.Interface:
+		return nil, false
+	case reflect.Slice, reflect.Array:
+		if strings.HasPrefix(s, "[") && json.Valid([]byte(s)) {
+			return json.RawMessage(s), true
+		}
+		if t.Elem().Kind() != reflect.String {
+			return nil, false
+		}
+		raw, _ := json.Marshal(splitList(s))
+		return raw, true
+	}
+	if !json.Valid([]byte(s)) {
+		return nil, false
+	}
+	return json.RawMessage(s), true
+}
+
+// fieldByName returns the field of struct t that encoding/json decodes
+// key into.
+func fieldByName(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func decode(r io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
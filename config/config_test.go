@@ -0,0 +1,295 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/shutdownmanagers/awsmanager"
+	"github.com/Zemanta/gracefulshutdown/shutdownmanagers/filemanager"
+	"github.com/Zemanta/gracefulshutdown/shutdownmanagers/posixsignal"
+)
+
+func TestLoad(t *testing.T) {
+	config, err := Load(strings.NewReader(`{
+		"managers": [
+			{"name": "PosixSignalManager", "config": {"signals": ["TERM", "SIGHUP"], "noExit": true}},
+			{"name": "AwsManager", "config": {"sqsQueueName": "queue", "pingTime": "5m", "port": 7999}},
+			{"name": "FileManager", "config": {"path": "/tmp/drain", "pollInterval": "250ms"}}
+		]
+	}`))
+	if err != nil {
+		t.Fatal("Load:", err)
+	}
+
+	managers, err := config.Build()
+	if err != nil {
+		t.Fatal("Build:", err)
+	}
+
+	names := []string{posixsignal.Name, awsmanager.Name, filemanager.Name}
+	if len(managers) != len(names) {
+		t.Fatal("Expected", len(names), "managers, got", len(managers))
+	}
+	for i, manager := range managers {
+		if manager.GetName() != names[i] {
+			t.Error("Expected", names[i], "got", manager.GetName())
+		}
+	}
+
+	gs := gracefulshutdown.New()
+	if err := config.Apply(gs); err != nil {
+		t.Error("Apply:", err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, c := range []string{
+		`{"managers": [{"name": "NoSuchManager"}]}`,
+		`{"managers": [{"name": "FileManager", "config": {"pth": "/tmp/drain"}}]}`,
+		`{"managers": [{"name": "FileManager", "config": {"pollInterval": "soon"}}]}`,
+		`{"managers": [{"name": "PosixSignalManager", "config": {"signals": ["SIGNOPE"]}}]}`,
+		`{"timeout": "soon"}`,
+		`{"timeout": 60}`,
+		`{"managers": [{"name": "AwsManager", "config": {"pingTime": 300}}]}`,
+	} {
+		config, err := Load(strings.NewReader(c))
+		if err == nil {
+			_, err = config.Build()
+		}
+		if err == nil {
+			t.Error("Expected error for", c)
+		}
+	}
+
+	_, err := LoadYAML(strings.NewReader("timeout: 60\n"))
+	if err == nil || !strings.Contains(err.Error(), `like "60s"`) {
+		t.Error("Expected error asking for unit, got", err)
+	}
+
+	if _, err := Load(strings.NewReader(`{"tmeout": "30s"}`)); err == nil {
+		t.Error("Expected error for unknown field tmeout.")
+	}
+
+	for _, c := range []string{
+		`{"phases": [{"timeout": "1s"}]}`,
+		`{"phases": [{"name": "drain"}, {"name": "drain"}]}`,
+	} {
+		config, err := Load(strings.NewReader(c))
+		if err != nil {
+			t.Fatal("Load:", err)
+		}
+		if err := config.Apply(gracefulshutdown.New()); err == nil {
+			t.Error("Expected error for", c)
+		}
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	config, err := LoadYAML(strings.NewReader(`
+timeout: 60s
+phases:
+  - name: drain
+    timeout: 30s
+  - name: close
+exit:
+  code: 0
+  timeoutCode: 1
+managers:
+  - name: PosixSignalManager
+    config:
+      signals: [SIGTERM]
+      noExit: true
+  - name: AwsManager
+    config:
+      sqsQueueName: 12345
+      port: 7999
+`))
+	if err != nil {
+		t.Fatal("LoadYAML:", err)
+	}
+
+	if time.Duration(config.Timeout) != time.Minute {
+		t.Error("Expected timeout 1m, got", time.Duration(config.Timeout))
+	}
+	if len(config.Phases) != 2 || config.Phases[0].Name != "drain" || time.Duration(config.Phases[0].Timeout) != time.Second*30 || config.Phases[1].Name != "close" {
+		t.Error("Unexpected phases", config.Phases)
+	}
+	if config.Exit == nil || config.Exit.Code != 0 || config.Exit.TimeoutCode != 1 {
+		t.Error("Unexpected exit", config.Exit)
+	}
+
+	var aws struct {
+		SqsQueueName string `json:"sqsQueueName"`
+		Port         uint16 `json:"port"`
+	}
+	if err := Unmarshal(config.Managers[1].Config, &aws); err != nil {
+		t.Fatal("Unmarshal:", err)
+	}
+	if aws.SqsQueueName != "12345" || aws.Port != 7999 {
+		t.Error("Unexpected AwsManager config", string(config.Managers[1].Config))
+	}
+
+	if _, err := config.Build(); err != nil {
+		t.Error("Build:", err)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"shutdown.json": `{"timeout": "5s"}`,
+		"shutdown.yaml": "timeout: 5s\n",
+		"shutdown.yml":  "timeout: 5s\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		config, err := LoadFile(path)
+		if err != nil {
+			t.Error("LoadFile", name, err)
+			continue
+		}
+		if time.Duration(config.Timeout) != time.Second*5 {
+			t.Error("Expected timeout 5s from", name, "got", time.Duration(config.Timeout))
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	config := &Config{
+		Timeout: Duration(time.Second),
+		Phases:  []PhaseConfig{{Name: "drain"}, {Name: "close"}},
+	}
+
+	c := make(chan string, 100)
+	gs := gracefulshutdown.New()
+	for _, phase := range []string{"close", "drain"} {
+		phase := phase
+		gs.AddPhaseCallback(gracefulshutdown.Phase(phase), gracefulshutdown.ShutdownFunc(func(string) error {
+			c <- phase
+			return nil
+		}))
+	}
+
+	if err := config.Apply(gs); err != nil {
+		t.Fatal("Apply:", err)
+	}
+
+	gs.StartShutdown(posixsignal.NewPosixSignalManagerWithConfig(&posixsignal.PosixSignalManagerConfig{NoExit: true}))
+
+	if len(c) != 2 || <-c != "drain" || <-c != "close" {
+		t.Error("Expected phases in configured order.")
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("GS_MANAGERS", "FileManager, PosixSignalManager")
+	t.Setenv("GS_FILEMANAGER_PATH", "/tmp/drain")
+	t.Setenv("GS_FILEMANAGER_POLLINTERVAL", "2s")
+	t.Setenv("GS_POSIXSIGNALMANAGER_SIGNALS", "SIGINT, SIGHUP")
+	t.Setenv("GS_POSIXSIGNALMANAGER_ORDER", "3")
+	t.Setenv("GS_POSIXSIGNALMANAGER_NOEXIT", "true")
+	t.Setenv("GS_TIMEOUT", "1m")
+	t.Setenv("GS_PHASES", "drain:30s, close")
+	t.Setenv("GS_EXIT_TIMEOUTCODE", "1")
+
+	config, err := LoadEnv("GS")
+	if err != nil {
+		t.Fatal("LoadEnv:", err)
+	}
+
+	if len(config.Managers) != 2 {
+		t.Fatal("Expected 2 managers, got", len(config.Managers))
+	}
+
+	var file struct {
+		Path         string
+		PollInterval Duration
+	}
+	if err := Unmarshal(config.Managers[0].Config, &file); err != nil {
+		t.Fatal("Unmarshal:", err)
+	}
+	if file.Path != "/tmp/drain" || time.Duration(file.PollInterval) != time.Second*2 {
+		t.Error("Unexpected FileManager config", string(config.Managers[0].Config))
+	}
+
+	var posix struct {
+		Signals []string
+		Order   int
+		NoExit  bool
+	}
+	if err := Unmarshal(config.Managers[1].Config, &posix); err != nil {
+		t.Fatal("Unmarshal:", err)
+	}
+	if len(posix.Signals) != 2 || posix.Signals[1] != "SIGHUP" || posix.Order != 3 || !posix.NoExit {
+		t.Error("Unexpected PosixSignalManager config", string(config.Managers[1].Config))
+	}
+
+	if time.Duration(config.Timeout) != time.Minute {
+		t.Error("Expected timeout 1m, got", time.Duration(config.Timeout))
+	}
+	if len(config.Phases) != 2 || time.Duration(config.Phases[0].Timeout) != time.Second*30 || config.Phases[1].Name != "close" {
+		t.Error("Unexpected phases", config.Phases)
+	}
+	if config.Exit == nil || config.Exit.TimeoutCode != 1 {
+		t.Error("Unexpected exit", config.Exit)
+	}
+
+	if _, err := config.Build(); err != nil {
+		t.Error("Build:", err)
+	}
+}
+
+func TestLoadEnvTypes(t *testing.T) {
+	for _, value := range []string{"12345", "true", `["queue"]`} {
+		t.Setenv("GS_MANAGERS", "AwsManager")
+		t.Setenv("GS_AWSMANAGER_SQSQUEUENAME", value)
+		t.Setenv("GS_AWSMANAGER_PORT", "7999")
+
+		config, err := LoadEnv("GS")
+		if err != nil {
+			t.Fatal("LoadEnv:", err)
+		}
+
+		var aws struct {
+			SqsQueueName string `json:"sqsQueueName"`
+			Port         uint16 `json:"port"`
+		}
+		if err := Unmarshal(config.Managers[0].Config, &aws); err != nil {
+			t.Error("Unmarshal", value, err)
+			continue
+		}
+		if aws.SqsQueueName != value || aws.Port != 7999 {
+			t.Error("Expected queue", value, "got", aws.SqsQueueName, aws.Port)
+		}
+
+		if _, err := config.Build(); err != nil {
+			t.Error("Build:", err)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("TestManager", func(config json.RawMessage) (gracefulshutdown.ShutdownManager, error) {
+		return posixsignal.NewPosixSignalManager(), nil
+	})
+
+	config := &Config{Managers: []ManagerConfig{{Name: "TestManager"}}}
+	if _, err := config.Build(); err != nil {
+		t.Error("Build:", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic on duplicate Register.")
+		}
+	}()
+	Register("TestManager", nil)
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/shutdownmanagers/awsmanager"
+	"github.com/Zemanta/gracefulshutdown/shutdownmanagers/filemanager"
+	"github.com/Zemanta/gracefulshutdown/shutdownmanagers/posixsignal"
+)
+
+func init() {
+	Register(posixsignal.Name, newPosixSignalManager)
+	Register(awsmanager.Name, newAwsManager)
+	Register(filemanager.Name, newFileManager)
+}
+
+// Duration is a time.Duration that is a string like "1m30s" in JSON.
+// Numbers without a unit are rejected, so timeout: 60 in YAML is not
+// read as 60 nanoseconds.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var n json.Number
+		if err := json.Unmarshal(b, &n); err == nil {
+			return fmt.Errorf("duration %s needs a unit, like \"%ss\"", n, n)
+		}
+		return fmt.Errorf("invalid duration %s", b)
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+var signals = map[string]os.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+func newPosixSignalManager(config json.RawMessage) (gracefulshutdown.ShutdownManager, error) {
+	var c struct {
+		Signals []string `json:"signals"`
+		Order   int      `json:"order"`
+		NoExit  bool     `json:"noExit"`
+		Reset   bool     `json:"reset"`
+	}
+	if err := Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+
+	posixSignalManagerConfig := &posixsignal.PosixSignalManagerConfig{
+		Order:  c.Order,
+		NoExit: c.NoExit,
+		Reset:  c.Reset,
+	}
+	for _, name := range c.Signals {
+		name = strings.ToUpper(name)
+		if !strings.HasPrefix(name, "SIG") {
+			name = "SIG" + name
+		}
+		sig, ok := signals[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown signal %q", name)
+		}
+		posixSignalManagerConfig.Signals = append(posixSignalManagerConfig.Signals, sig)
+	}
+	return posixsignal.NewPosixSignalManagerWithConfig(posixSignalManagerConfig), nil
+}
+
+func newAwsManager(config json.RawMessage) (gracefulshutdown.ShutdownManager, error) {
+	var c struct {
+		SqsQueueName             string   `json:"sqsQueueName"`
+		LifecycleHookName        string   `json:"lifecycleHookName"`
+		PingTime                 Duration `json:"pingTime"`
+		Port                     uint16   `json:"port"`
+		BackOff                  float64  `json:"backOff"`
+		NumServeRetries          int      `json:"numServeRetries"`
+		NumForwardRetries        int      `json:"numForwardRetries"`
+		UnhealthyReceiveFailures int      `json:"unhealthyReceiveFailures"`
+		Region                   string   `json:"region"`
+		InstanceId               string   `json:"instanceId"`
+	}
+	if err := Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+
+	return awsmanager.NewAwsManager(&awsmanager.AwsManagerConfig{
+		SqsQueueName:             c.SqsQueueName,
+		LifecycleHookName:        c.LifecycleHookName,
+		PingTime:                 time.Duration(c.PingTime),
+		Port:                     c.Port,
+		BackOff:                  c.BackOff,
+		NumServeRetries:          c.NumServeRetries,
+		NumForwardRetries:        c.NumForwardRetries,
+		UnhealthyReceiveFailures: c.UnhealthyReceiveFailures,
+		Region:                   c.Region,
+		InstanceId:               c.InstanceId,
+	}), nil
+}
+
+func newFileManager(config json.RawMessage) (gracefulshutdown.ShutdownManager, error) {
+	var c struct {
+		Path         string   `json:"path"`
+		PollInterval Duration `json:"pollInterval"`
+	}
+	if err := Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+
+	return filemanager.NewFileManager(&filemanager.FileManagerConfig{
+		Path:         c.Path,
+		PollInterval: time.Duration(c.PollInterval),
+	}), nil
+}
@@ -0,0 +1,195 @@
+/*
+Package errorhandler provides ErrorHandlers that wrap other ErrorHandlers
+to filter, deduplicate and rate limit errors. They can be combined and
+added to GracefulShutdown with AddErrorHandler:
+
+	// log everything, but collapse repeated errors
+	gs.AddErrorHandler(errorhandler.Dedup(logHandler, time.Minute))
+
+	// alert on critical errors, at most 5 per hour
+	gs.AddErrorHandler(errorhandler.Filter(
+		errorhandler.RateLimit(alertHandler, 5, time.Hour),
+		errorhandler.MinSeverity(gracefulshutdown.SeverityCritical),
+	))
+*/
+package errorhandler
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+// Filter returns an ErrorHandler that passes errors to handler only
+// if accept returns true.
+func Filter(handler gracefulshutdown.ErrorHandler, accept func(err error) bool) gracefulshutdown.ErrorHandler {
+	return gracefulshutdown.ErrorFunc(func(err error) {
+		if accept(err) {
+			handler.OnError(err)
+		}
+	})
+}
+
+// MinSeverity returns a filter for errors with at least severity.
+// Errors that are not gracefulshutdown.Error have SeverityError.
+func MinSeverity(severity gracefulshutdown.Severity) func(err error) bool {
+	return func(err error) bool {
+		return severityOf(err) >= severity
+	}
+}
+
+// FromSource returns a filter for errors from the given sources, like
+// ShutdownManager names.
+func FromSource(sources ...string) func(err error) bool {
+	return func(err error) bool {
+		var gsErr *gracefulshutdown.Error
+		if !errors.As(err, &gsErr) {
+			return false
+		}
+		for _, source := range sources {
+			if gsErr.Source == source {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func severityOf(err error) gracefulshutdown.Severity {
+	var gsErr *gracefulshutdown.Error
+	if errors.As(err, &gsErr) {
+		return gsErr.Severity
+	}
+	return gracefulshutdown.SeverityError
+}
+
+// RepeatedError is reported by Dedup when an error was repeated.
+// Err is the last repeated error.
+type RepeatedError struct {
+	Err   error
+	Count int
+}
+
+func (e *RepeatedError) Error() string {
+	return fmt.Sprintf("%v (repeated %d times)", e.Err, e.Count)
+}
+
+func (e *RepeatedError) Unwrap() error {
+	return e.Err
+}
+
+type dedup struct {
+	handler gracefulshutdown.ErrorHandler
+	window  time.Duration
+
+	mutex    sync.Mutex
+	repeated map[string]*RepeatedError
+}
+
+// Dedup returns an ErrorHandler that passes the first of identical errors
+// to handler and collapses the ones repeated in the next window into one
+// RepeatedError, reported when the window ends. Errors are identical if
+// they have the same message, and the same source and action for
+// gracefulshutdown.Error.
+func Dedup(handler gracefulshutdown.ErrorHandler, window time.Duration) gracefulshutdown.ErrorHandler {
+	return &dedup{
+		handler:  handler,
+		window:   window,
+		repeated: make(map[string]*RepeatedError),
+	}
+}
+
+func (d *dedup) OnError(err error) {
+	key := err.Error()
+	var gsErr *gracefulshutdown.Error
+	if errors.As(err, &gsErr) {
+		key = gsErr.Source + "\x00" + gsErr.Action + "\x00" + key
+	}
+
+	d.mutex.Lock()
+	if repeated, ok := d.repeated[key]; ok {
+		repeated.Err = err
+		repeated.Count++
+		d.mutex.Unlock()
+		return
+	}
+	d.repeated[key] = &RepeatedError{}
+	d.mutex.Unlock()
+
+	time.AfterFunc(d.window, func() {
+		d.mutex.Lock()
+		repeated := d.repeated[key]
+		delete(d.repeated, key)
+		d.mutex.Unlock()
+
+		if repeated.Count > 0 {
+			d.handler.OnError(repeated)
+		}
+	})
+
+	d.handler.OnError(err)
+}
+
+// DroppedError is reported by RateLimit when errors were dropped.
+type DroppedError struct {
+	Count int
+}
+
+func (e *DroppedError) Error() string {
+	return fmt.Sprintf("%d errors dropped by rate limit", e.Count)
+}
+
+type rateLimit struct {
+	handler  gracefulshutdown.ErrorHandler
+	limit    int
+	interval time.Duration
+
+	mutex   sync.Mutex
+	passed  int
+	dropped int
+	timer   *time.Timer
+}
+
+// RateLimit returns an ErrorHandler that passes at most limit errors
+// per interval to handler. Dropped errors are counted and reported as
+// DroppedError when the interval ends.
+func RateLimit(handler gracefulshutdown.ErrorHandler, limit int, interval time.Duration) gracefulshutdown.ErrorHandler {
+	return &rateLimit{
+		handler:  handler,
+		limit:    limit,
+		interval: interval,
+	}
+}
+
+func (rl *rateLimit) OnError(err error) {
+	rl.mutex.Lock()
+	if rl.timer == nil {
+		rl.timer = time.AfterFunc(rl.interval, rl.reset)
+	}
+	if rl.passed >= rl.limit {
+		rl.dropped++
+		rl.mutex.Unlock()
+		return
+	}
+	rl.passed++
+	rl.mutex.Unlock()
+
+	rl.handler.OnError(err)
+}
+
+// reset starts a new interval and reports dropped errors.
+func (rl *rateLimit) reset() {
+	rl.mutex.Lock()
+	dropped := rl.dropped
+	rl.passed = 0
+	rl.dropped = 0
+	rl.timer = nil
+	rl.mutex.Unlock()
+
+	if dropped > 0 {
+		rl.handler.OnError(&DroppedError{Count: dropped})
+	}
+}
@@ -0,0 +1,110 @@
+package errorhandler
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+func collect() (chan error, gracefulshutdown.ErrorHandler) {
+	c := make(chan error, 100)
+	return c, gracefulshutdown.ErrorFunc(func(err error) {
+		c <- err
+	})
+}
+
+func TestFilter(t *testing.T) {
+	c, handler := collect()
+	filtered := Filter(handler, MinSeverity(gracefulshutdown.SeverityError))
+
+	filtered.OnError(gracefulshutdown.NewError("AwsManager", "receive", gracefulshutdown.SeverityWarning, errors.New("warning")))
+	filtered.OnError(gracefulshutdown.NewError("AwsManager", "complete", gracefulshutdown.SeverityCritical, errors.New("critical")))
+	filtered.OnError(errors.New("plain"))
+
+	if len(c) != 2 {
+		t.Fatal("Expected 2 errors, got", len(c))
+	}
+	if err := <-c; err.Error() != "critical" {
+		t.Error("Expected critical error, got", err)
+	}
+
+	filtered = Filter(handler, FromSource("AwsManager"))
+	filtered.OnError(gracefulshutdown.NewError("FileManager", "poll", gracefulshutdown.SeverityWarning, errors.New("file")))
+	filtered.OnError(gracefulshutdown.NewError("AwsManager", "receive", gracefulshutdown.SeverityWarning, errors.New("aws")))
+
+	<-c
+	if err := <-c; err.Error() != "aws" || len(c) != 0 {
+		t.Error("Expected only aws error, got", err)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	c, handler := collect()
+	deduped := Dedup(handler, time.Millisecond*20)
+
+	for i := 0; i < 5; i++ {
+		deduped.OnError(gracefulshutdown.NewError("AwsManager", "receive", gracefulshutdown.SeverityWarning, errors.New("unreachable")))
+	}
+	deduped.OnError(gracefulshutdown.NewError("AwsManager", "heartbeat", gracefulshutdown.SeverityError, errors.New("unreachable")))
+
+	if len(c) != 2 {
+		t.Fatal("Expected 2 errors before window ends, got", len(c))
+	}
+	<-c
+	<-c
+
+	var repeated *RepeatedError
+	select {
+	case err := <-c:
+		if !errors.As(err, &repeated) || repeated.Count != 4 {
+			t.Fatal("Expected error repeated 4 times, got", err)
+		}
+
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for RepeatedError.")
+	}
+
+	var gsErr *gracefulshutdown.Error
+	if !errors.As(repeated, &gsErr) || gsErr.Action != "receive" {
+		t.Error("Expected RepeatedError to wrap the repeated error.")
+	}
+
+	time.Sleep(time.Millisecond * 30)
+	if len(c) != 0 {
+		t.Error("Expected no RepeatedError for error that was not repeated, got", <-c)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	c, handler := collect()
+	limited := RateLimit(handler, 3, time.Millisecond*20)
+
+	for i := 0; i < 10; i++ {
+		limited.OnError(errors.New("flood"))
+	}
+
+	if len(c) != 3 {
+		t.Fatal("Expected 3 errors, got", len(c))
+	}
+	<-c
+	<-c
+	<-c
+
+	var dropped *DroppedError
+	select {
+	case err := <-c:
+		if !errors.As(err, &dropped) || dropped.Count != 7 {
+			t.Fatal("Expected 7 dropped errors, got", err)
+		}
+
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for DroppedError.")
+	}
+
+	limited.OnError(errors.New("after"))
+	if err := <-c; err.Error() != "after" {
+		t.Error("Expected error after interval, got", err)
+	}
+}
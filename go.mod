@@ -0,0 +1,10 @@
+module github.com/Zemanta/gracefulshutdown
+
+go 1.24
+
+require (
+	github.com/aws/aws-sdk-go v1.55.8
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require github.com/jmespath/go-jmespath v0.4.0 // indirect
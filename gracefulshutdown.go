@@ -1,18 +1,18 @@
 /*
-
 Providing shutdown callbacks for graceful app shutdown
 
-Installation
+# Installation
 
 To install run:
 
 	go get github.com/Zemanta/gracefulshutdown
 
-Example - posix signals
+# Example - posix signals
 
 Graceful shutdown will listen for posix SIGINT and SIGTERM signals.
 When they are received it will run all callbacks in separate go routines.
 When callbacks return, the application will exit with os.Exit(0)
+
 	package main
 
 	import (
@@ -48,7 +48,7 @@ When callbacks return, the application will exit with os.Exit(0)
 		time.Sleep(time.Hour)
 	}
 
-Example - posix signals with error handler
+# Example - posix signals with error handler
 
 The same as above, except now we set an ErrorHandler that prints the
 error returned from ShutdownCallback.
@@ -94,7 +94,7 @@ error returned from ShutdownCallback.
 		time.Sleep(time.Hour)
 	}
 
-Example - aws
+# Example - aws
 
 Graceful shutdown will listen for SQS messages on "example-sqs-queue".
 If a termination message has current EC2 instance id,
@@ -105,6 +105,7 @@ When callbacks return, the application will call aws api CompleteLifecycleAction
 The callback will delay only if shutdown was initiated by awsmanager.
 If the message does not have current instance id, it will forward the
 message to correct instance via http on port 7999.
+
 	package main
 
 	import (
@@ -158,9 +159,21 @@ message to correct instance via http on port 7999.
 package gracefulshutdown
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
 	"sync"
+	"time"
 )
 
+// ErrTimeout is reported for ShutdownCallbacks that did not return
+// before the timeout set with SetTimeout or SetPhase.
+var ErrTimeout = errors.New("shutdown timeout expired")
+
+// exit is replaced in tests.
+var exit = os.Exit
+
 // ShutdownCallback is an interface you have to implement for callbacks.
 // OnShutdown will be called when shutdown is requested. The parameter
 // is the name of the ShutdownManager that requested shutdown.
@@ -189,6 +202,28 @@ type ShutdownManager interface {
 	ShutdownFinish() error
 }
 
+// Stopper is an optional interface implemented by ShutdownManagers that
+// can stop listening for shutdown requests. If Start fails, Stop is
+// called on ShutdownManagers that were already started, so no
+// goroutines or listeners are left behind. Stop must be safe to call
+// more than once, and Start must work again after Stop, so Start of
+// GracefulShutdown can be retried.
+type Stopper interface {
+	Stop() error
+}
+
+// HealthChecker is an optional interface implemented by ShutdownManagers
+// that can tell if they are still able to receive shutdown requests,
+// for example if their listener failed. Health returns nil if the
+// ShutdownManager is healthy.
+//
+// GracefulShutdown implements it too, combining health of all its
+// ShutdownManagers, so ShutdownManagers serving readiness checks can
+// check if GSInterface implements it.
+type HealthChecker interface {
+	Health() error
+}
+
 // ErrorHandler is an interface you can pass to SetErrorHandler to
 // handle asynchronous errors.
 type ErrorHandler interface {
@@ -203,6 +238,197 @@ func (f ErrorFunc) OnError(err error) {
 	f(err)
 }
 
+// Severity is the severity of an Error.
+type Severity int
+
+// Severities are declared from least to most severe, so they can be
+// compared with < and >=.
+const (
+	// SeverityWarning is used for errors that are retried or do not
+	// affect shutdown, like a failed poll.
+	SeverityWarning Severity = -1
+
+	// SeverityError is the default severity.
+	SeverityError Severity = 0
+
+	// SeverityCritical is used for errors that make shutdown fail or
+	// force the process to exit.
+	SeverityCritical Severity = 1
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityCritical:
+		return "critical"
+	default:
+		return "error"
+	}
+}
+
+// Error is the error reported to ErrorHandler by GracefulShutdown and
+// bundled ShutdownManagers. It tells where the error came from, use
+// errors.As to get it:
+//
+//	var gsErr *gracefulshutdown.Error
+//	if errors.As(err, &gsErr) && gsErr.Severity == gracefulshutdown.SeverityCritical {
+//		// page someone
+//	}
+type Error struct {
+	// Source is the name of ShutdownManager, or the ShutdownCallback
+	// as in Event.Callback.
+	Source string
+
+	// Phase is the phase of shutdown, empty for errors outside of shutdown.
+	Phase Phase
+
+	// Action is what the ShutdownManager was doing, for example "heartbeat".
+	Action string
+
+	Severity Severity
+
+	Err error
+}
+
+// NewError wraps err in Error. Returns nil if err is nil, so it can
+// be passed directly to ReportError.
+func NewError(source, action string, severity Severity, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{
+		Source:   source,
+		Action:   action,
+		Severity: severity,
+		Err:      err,
+	}
+}
+
+// Error returns the message of Err, so wrapping does not change
+// the text of reported errors.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// withPhase wraps err in Error with source and phase. If err already is
+// an Error, only its empty Source and Phase are set.
+func withPhase(err error, source string, phase Phase) error {
+	if err == nil {
+		return nil
+	}
+	gsErr, ok := err.(*Error)
+	if !ok {
+		return &Error{
+			Source: source,
+			Phase:  phase,
+			Err:    err,
+		}
+	}
+
+	wrapped := *gsErr
+	if wrapped.Source == "" {
+		wrapped.Source = source
+	}
+	if wrapped.Phase == "" {
+		wrapped.Phase = phase
+	}
+	return &wrapped
+}
+
+// EventType identifies a step of shutdown reported to EventHandler.
+type EventType string
+
+const (
+	// EventShutdownRequested is reported when a ShutdownManager
+	// calls StartShutdown.
+	EventShutdownRequested EventType = "shutdown_requested"
+
+	// EventPhaseStarted and EventPhaseFinished are reported around
+	// each Phase of shutdown.
+	EventPhaseStarted  EventType = "phase_started"
+	EventPhaseFinished EventType = "phase_finished"
+
+	// EventCallbackStarted and EventCallbackFinished are reported around
+	// each ShutdownCallback.
+	EventCallbackStarted  EventType = "callback_started"
+	EventCallbackFinished EventType = "callback_finished"
+
+	// EventManager is reported by ShutdownManagers for their own actions,
+	// like calls to external services.
+	EventManager EventType = "manager"
+)
+
+// Phase is a phase of shutdown. Phases added with SetPhase or
+// AddPhaseCallback run between PhaseCallbacks and PhaseShutdownFinish.
+type Phase string
+
+const (
+	PhaseShutdownStart  Phase = "ShutdownStart"
+	PhaseCallbacks      Phase = "Callbacks"
+	PhaseShutdownFinish Phase = "ShutdownFinish"
+)
+
+// Event describes a step of shutdown. Fields that do not apply to
+// the Type are empty.
+type Event struct {
+	Type EventType
+
+	// Manager is the name of ShutdownManager that requested shutdown
+	// or reported the event.
+	Manager string
+
+	Phase Phase
+
+	// Callback identifies the ShutdownCallback by its type and the order
+	// in which it was added, for example "gracefulshutdown.ShutdownFunc#2".
+	Callback string
+
+	// Action is the action of EventManager, for example "heartbeat".
+	Action string
+
+	// Fields are additional details of EventManager.
+	Fields map[string]interface{}
+
+	// Duration is set on finished events and EventManager.
+	Duration time.Duration
+
+	// Err is the error of a finished phase, callback or manager action.
+	// It is also reported to ErrorHandler.
+	Err error
+}
+
+// EventHandler is an interface you can pass to SetEventHandler to
+// follow the progress of shutdown. OnEvent is called concurrently for
+// callbacks running at the same time.
+type EventHandler interface {
+	OnEvent(event Event)
+}
+
+// EventFunc is a helper type, so you can easily provide anonymous functions
+// as EventHandlers.
+type EventFunc func(event Event)
+
+func (f EventFunc) OnEvent(event Event) {
+	f(event)
+}
+
+// EventReporter is implemented by GracefulShutdown. ShutdownManagers can
+// check if GSInterface implements it to report EventManager events.
+type EventReporter interface {
+	ReportEvent(event Event)
+}
+
+// DoneNotifier is implemented by GracefulShutdown. ShutdownManagers can
+// check if GSInterface implements it to wait for shutdown to finish.
+type DoneNotifier interface {
+	Done() <-chan struct{}
+}
+
 // GSInterface is an interface implemented by GracefulShutdown,
 // that gets passed to ShutdownManager to call StartShutdown when shutdown
 // is requested.
@@ -212,28 +438,66 @@ type GSInterface interface {
 	AddShutdownCallback(shutdownCallback ShutdownCallback)
 }
 
+// ExitPolicy makes GracefulShutdown exit the process when shutdown
+// finishes, see SetExitPolicy.
+type ExitPolicy struct {
+	// Code is the exit code after all ShutdownCallbacks returned.
+	Code int
+
+	// TimeoutCode is the exit code if a timeout expired before
+	// all ShutdownCallbacks returned.
+	TimeoutCode int
+}
+
+// phase is a group of ShutdownCallbacks added with AddPhaseCallback.
+type phase struct {
+	name      Phase
+	timeout   time.Duration
+	declared  bool
+	callbacks []ShutdownCallback
+}
+
 // GracefulShutdown is main struct that handles ShutdownCallbacks and
 // ShutdownManagers. Initialize it with New.
 type GracefulShutdown struct {
-	callbacks    []ShutdownCallback
-	managers     []ShutdownManager
-	errorHandler ErrorHandler
+	callbacks     []ShutdownCallback
+	phases        []*phase
+	managers      []ShutdownManager
+	errorHandlers []ErrorHandler
+	eventHandler  EventHandler
+	timeout       time.Duration
+	exitPolicy    *ExitPolicy
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	done     chan struct{}
+	doneOnce sync.Once
 }
 
 // New initializes GracefulShutdown.
 func New() *GracefulShutdown {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &GracefulShutdown{
 		callbacks: make([]ShutdownCallback, 0, 10),
 		managers:  make([]ShutdownManager, 0, 3),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
 	}
 }
 
 // Start calls Start on all added ShutdownManagers. The ShutdownManagers
 // start to listen to shutdown requests. Returns an error if any ShutdownManagers
-// return an error.
+// return an error. In that case ShutdownManagers that were already started
+// are stopped in reverse order if they implement Stopper, and
+// ShutdownCallbacks they added are removed, so Start can be retried.
 func (gs *GracefulShutdown) Start() error {
-	for _, manager := range gs.managers {
+	callbacks := len(gs.callbacks)
+	for i, manager := range gs.managers {
 		if err := manager.Start(gs); err != nil {
+			gs.stop(gs.managers[:i])
+			gs.callbacks = gs.callbacks[:callbacks]
 			return err
 		}
 	}
@@ -241,6 +505,32 @@ func (gs *GracefulShutdown) Start() error {
 	return nil
 }
 
+// stop calls Stop on managers that implement Stopper, in reverse order.
+func (gs *GracefulShutdown) stop(managers []ShutdownManager) {
+	for i := len(managers) - 1; i >= 0; i-- {
+		if stopper, ok := managers[i].(Stopper); ok {
+			gs.ReportError(NewError(managers[i].GetName(), "stop", SeverityError, stopper.Stop()))
+		}
+	}
+}
+
+// Health calls Health on all added ShutdownManagers that implement
+// HealthChecker. Returns nil if all of them are healthy, or their errors
+// joined, each wrapped in Error with the name of the ShutdownManager as
+// Source. Can be used in readiness checks, so the process is not
+// reported ready when a shutdown request could be missed.
+func (gs *GracefulShutdown) Health() error {
+	var errs []error
+	for _, manager := range gs.managers {
+		if healthChecker, ok := manager.(HealthChecker); ok {
+			if err := NewError(manager.GetName(), "health", SeverityError, healthChecker.Health()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // AddShutdownManager adds a ShutdownManager that will listen to shutdown requests.
 func (gs *GracefulShutdown) AddShutdownManager(manager ShutdownManager) {
 	gs.managers = append(gs.managers, manager)
@@ -251,6 +541,7 @@ func (gs *GracefulShutdown) AddShutdownManager(manager ShutdownManager) {
 //
 // You can provide anything that implements ShutdownCallback interface,
 // or you can supply a function like this:
+//
 //	AddShutdownCallback(gracefulshutdown.ShutdownFunc(func() error {
 //		// callback code
 //		return nil
@@ -259,44 +550,286 @@ func (gs *GracefulShutdown) AddShutdownCallback(shutdownCallback ShutdownCallbac
 	gs.callbacks = append(gs.callbacks, shutdownCallback)
 }
 
+// SetPhase adds a phase of ShutdownCallbacks with the given timeout,
+// 0 for none, or sets the timeout of a phase that was already added.
+// Phases run one after another, after ShutdownCallbacks added with
+// AddShutdownCallback, in the order they were added with SetPhase.
+// Phases only used with AddPhaseCallback run last, in the order they
+// were first used.
+//
+// When the timeout of a phase expires, its ShutdownCallbacks that are
+// still running are reported with ErrTimeout and the next phase starts.
+// They are not stopped, so they can use Context to notice shutdown.
+func (gs *GracefulShutdown) SetPhase(name Phase, timeout time.Duration) {
+	declared := 0
+	for i, p := range gs.phases {
+		if p.name == name {
+			p.timeout = timeout
+			if !p.declared {
+				p.declared = true
+				copy(gs.phases[declared+1:i+1], gs.phases[declared:i])
+				gs.phases[declared] = p
+			}
+			return
+		}
+		if p.declared {
+			declared = i + 1
+		}
+	}
+
+	gs.phases = append(gs.phases, nil)
+	copy(gs.phases[declared+1:], gs.phases[declared:])
+	gs.phases[declared] = &phase{name: name, timeout: timeout, declared: true}
+}
+
+// AddPhaseCallback adds a ShutdownCallback that will be called in the
+// named phase, see SetPhase. Callbacks of a phase run concurrently, like
+// those added with AddShutdownCallback, which run in PhaseCallbacks.
+func (gs *GracefulShutdown) AddPhaseCallback(name Phase, shutdownCallback ShutdownCallback) {
+	for _, p := range gs.phases {
+		if p.name == name {
+			p.callbacks = append(p.callbacks, shutdownCallback)
+			return
+		}
+	}
+	gs.phases = append(gs.phases, &phase{name: name, callbacks: []ShutdownCallback{shutdownCallback}})
+}
+
+// SetTimeout sets the time all ShutdownCallbacks together have to return,
+// 0 for no limit, which is the default. When it expires, callbacks that
+// are still running are reported with ErrTimeout, phases that did not
+// start yet are skipped and ShutdownFinish is called.
+func (gs *GracefulShutdown) SetTimeout(timeout time.Duration) {
+	gs.timeout = timeout
+}
+
+// SetExitPolicy makes StartShutdown exit the process with os.Exit once
+// shutdown finishes, after Done is closed, with the exit code from
+// policy. Nil, the default, does not exit. ShutdownManagers do not
+// exit the process either, except PosixSignalManager, which exits after
+// a signal only if no policy is set.
+func (gs *GracefulShutdown) SetExitPolicy(policy *ExitPolicy) {
+	gs.exitPolicy = policy
+}
+
+// ExitPolicy returns the policy set with SetExitPolicy.
+func (gs *GracefulShutdown) ExitPolicy() *ExitPolicy {
+	return gs.exitPolicy
+}
+
 // SetErrorHandler sets an ErrorHandler that will be called when an error
 // is encountered in ShutdownCallback or in ShutdownManager.
 //
 // You can provide anything that implements ErrorHandler interface,
 // or you can supply a function like this:
+//
 //	SetErrorHandler(gracefulshutdown.ErrorFunc(func (err error) {
 //		// handle error
 //	}))
+//
+// SetErrorHandler replaces all ErrorHandlers added with AddErrorHandler.
 func (gs *GracefulShutdown) SetErrorHandler(errorHandler ErrorHandler) {
-	gs.errorHandler = errorHandler
+	gs.errorHandlers = nil
+	if errorHandler != nil {
+		gs.errorHandlers = append(gs.errorHandlers, errorHandler)
+	}
+}
+
+// AddErrorHandler adds an ErrorHandler, so errors are reported to several
+// handlers, for example logs and alerting. Package errorhandler provides
+// filtering, deduplication and rate limiting for them.
+func (gs *GracefulShutdown) AddErrorHandler(errorHandler ErrorHandler) {
+	gs.errorHandlers = append(gs.errorHandlers, errorHandler)
+}
+
+// SetEventHandler sets an EventHandler that will be called for
+// each step of shutdown, see Event.
+func (gs *GracefulShutdown) SetEventHandler(eventHandler EventHandler) {
+	gs.eventHandler = eventHandler
+}
+
+// Context returns a context that is cancelled when shutdown is started,
+// so code that uses context.Context can stop its work on shutdown.
+func (gs *GracefulShutdown) Context() context.Context {
+	return gs.ctx
+}
+
+// Done returns a channel that is closed when shutdown finishes, after
+// ShutdownFinish of the ShutdownManager that started it returns.
+func (gs *GracefulShutdown) Done() <-chan struct{} {
+	return gs.done
 }
 
 // StartShutdown is called from a ShutdownManager and will initiate shutdown:
-// first call ShutdownStart on Shutdownmanager,
+// cancel Context, first call ShutdownStart on Shutdownmanager,
 // call all ShutdownCallbacks, wait for callbacks to finish and
 // call ShutdownFinish on ShutdownManager
+//
+// Shutdown runs only once. If several ShutdownManagers request it, for
+// example a signal and an SQS message, later calls wait for the first
+// shutdown to finish and return without calling their ShutdownManager.
+// ShutdownCallbacks must not call StartShutdown.
 func (gs *GracefulShutdown) StartShutdown(sm ShutdownManager) {
-	gs.ReportError(sm.ShutdownStart())
+	gs.doneOnce.Do(func() {
+		gs.shutdown(sm)
+	})
+}
 
-	var wg sync.WaitGroup
-	for _, shutdownCallback := range gs.callbacks {
+// shutdown runs shutdown requested by sm, see StartShutdown.
+func (gs *GracefulShutdown) shutdown(sm ShutdownManager) {
+	if gs.cancel != nil {
+		gs.cancel()
+	}
+
+	name := sm.GetName()
+	gs.ReportEvent(Event{Type: EventShutdownRequested, Manager: name})
+
+	gs.ReportError(withPhase(gs.runPhase(name, PhaseShutdownStart, sm.ShutdownStart), name, PhaseShutdownStart))
+
+	var deadline time.Time
+	if gs.timeout > 0 {
+		deadline = time.Now().Add(gs.timeout)
+	}
+
+	timedOut := gs.runPhase(name, PhaseCallbacks, func() error {
+		return gs.runCallbacks(name, PhaseCallbacks, gs.callbacks, deadline)
+	}) != nil
+
+	for _, p := range gs.phases {
+		phaseDeadline := deadline
+		if p.timeout > 0 && (deadline.IsZero() || time.Until(deadline) > p.timeout) {
+			phaseDeadline = time.Now().Add(p.timeout)
+		}
+		if gs.runPhase(name, p.name, func() error {
+			return gs.runCallbacks(name, p.name, p.callbacks, phaseDeadline)
+		}) != nil {
+			timedOut = true
+		}
+	}
+
+	gs.ReportError(withPhase(gs.runPhase(name, PhaseShutdownFinish, sm.ShutdownFinish), name, PhaseShutdownFinish))
+
+	if gs.done != nil {
+		close(gs.done)
+	}
+
+	if gs.exitPolicy != nil {
+		if timedOut {
+			exit(gs.exitPolicy.TimeoutCode)
+		} else {
+			exit(gs.exitPolicy.Code)
+		}
+	}
+}
+
+// runCallbacks calls callbacks concurrently and waits for them to return,
+// or for deadline if it is not zero. Callbacks that did not return
+// before deadline are reported and ErrTimeout is returned. If deadline
+// already passed, callbacks are not called at all.
+func (gs *GracefulShutdown) runCallbacks(manager string, phase Phase, callbacks []ShutdownCallback, deadline time.Time) error {
+	if len(callbacks) == 0 {
+		return nil
+	}
+
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		for i, shutdownCallback := range callbacks {
+			gs.ReportError(&Error{
+				Source:   fmt.Sprintf("%T#%d", shutdownCallback, i),
+				Phase:    phase,
+				Action:   "skip",
+				Severity: SeverityCritical,
+				Err:      ErrTimeout,
+			})
+		}
+		return ErrTimeout
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		finished = make([]bool, len(callbacks))
+	)
+	for i, shutdownCallback := range callbacks {
 		wg.Add(1)
-		go func(shutdownCallback ShutdownCallback) {
+		go func(i int, callback string, shutdownCallback ShutdownCallback) {
 			defer wg.Done()
 
-			gs.ReportError(shutdownCallback.OnShutdown(sm.GetName()))
-		}(shutdownCallback)
+			gs.ReportEvent(Event{Type: EventCallbackStarted, Manager: manager, Phase: phase, Callback: callback})
+			start := time.Now()
+			err := shutdownCallback.OnShutdown(manager)
+			gs.ReportEvent(Event{Type: EventCallbackFinished, Manager: manager, Phase: phase, Callback: callback, Duration: time.Since(start), Err: err})
+
+			gs.ReportError(withPhase(err, callback, phase))
+
+			mutex.Lock()
+			finished[i] = true
+			mutex.Unlock()
+		}(i, fmt.Sprintf("%T#%d", shutdownCallback, i), shutdownCallback)
+	}
+
+	if deadline.IsZero() {
+		wg.Wait()
+		return nil
 	}
 
-	wg.Wait()
+	returned := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(returned)
+	}()
 
-	gs.ReportError(sm.ShutdownFinish())
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-returned:
+		return nil
+	case <-timer.C:
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var err error
+	for i, shutdownCallback := range callbacks {
+		if !finished[i] {
+			gs.ReportError(&Error{
+				Source:   fmt.Sprintf("%T#%d", shutdownCallback, i),
+				Phase:    phase,
+				Action:   "timeout",
+				Severity: SeverityCritical,
+				Err:      ErrTimeout,
+			})
+			err = ErrTimeout
+		}
+	}
+	return err
+}
+
+// runPhase runs f and reports events around it.
+func (gs *GracefulShutdown) runPhase(manager string, phase Phase, f func() error) error {
+	gs.ReportEvent(Event{Type: EventPhaseStarted, Manager: manager, Phase: phase})
+	start := time.Now()
+	err := f()
+	gs.ReportEvent(Event{Type: EventPhaseFinished, Manager: manager, Phase: phase, Duration: time.Since(start), Err: err})
+	return err
+}
+
+// ReportEvent is a function that can be used to report events to
+// EventHandler. It is used in ShutdownManagers through EventReporter.
+func (gs *GracefulShutdown) ReportEvent(event Event) {
+	if gs.eventHandler != nil {
+		gs.eventHandler.OnEvent(event)
+	}
 }
 
 // ReportError is a function that can be used to report errors to
 // ErrorHandler. It is used in ShutdownManagers.
 func (gs *GracefulShutdown) ReportError(err error) {
-	if err != nil && gs.errorHandler != nil {
-		gs.errorHandler.OnError(err)
+	if err == nil {
+		return
+	}
+	for _, errorHandler := range gs.errorHandlers {
+		errorHandler.OnError(err)
 	}
 }
@@ -1,7 +1,9 @@
 package gracefulshutdown
 
 import (
+	"context"
 	"errors"
+	"os"
 	"testing"
 	"time"
 )
@@ -60,6 +62,15 @@ func (f SMStartFunc) Start(gs GSInterface) error {
 	return f()
 }
 
+type SMStopper struct {
+	SMStartFunc
+	stop func() error
+}
+
+func (sm SMStopper) Stop() error {
+	return sm.stop()
+}
+
 func TestCallbacksGetCalled(t *testing.T) {
 	gs := New()
 
@@ -248,3 +259,456 @@ func TestShutdownManagerName(t *testing.T) {
 		t.Error("Expected shutdownManager to be 'test-sm'.")
 	}
 }
+
+func TestContextCancelledOnShutdown(t *testing.T) {
+	gs := New()
+
+	if gs.Context().Err() != nil {
+		t.Error("Context cancelled before shutdown.")
+	}
+
+	c := make(chan error, 100)
+	gs.AddShutdownCallback(ShutdownFunc(func(string) error {
+		c <- gs.Context().Err()
+		return nil
+	}))
+
+	gs.StartShutdown(SMFinishFunc(func() error {
+		return nil
+	}))
+
+	if err := <-c; err != context.Canceled {
+		t.Error("Expected context to be cancelled in callbacks, got", err)
+	}
+}
+
+func TestDoneClosedAfterShutdownFinish(t *testing.T) {
+	gs := New()
+
+	c := make(chan bool, 100)
+	gs.StartShutdown(SMFinishFunc(func() error {
+		select {
+		case <-gs.Done():
+			c <- true
+		default:
+			c <- false
+		}
+		return nil
+	}))
+
+	if <-c {
+		t.Error("Expected Done not to be closed in ShutdownFinish.")
+	}
+
+	select {
+	case <-gs.Done():
+	default:
+		t.Error("Expected Done to be closed after shutdown.")
+	}
+
+	gs.StartShutdown(SMFinishFunc(func() error {
+		return nil
+	}))
+}
+
+func TestShutdownRunsOnce(t *testing.T) {
+	gs := New()
+
+	c := make(chan int, 100)
+	release := make(chan struct{})
+	gs.AddShutdownCallback(ShutdownFunc(func(string) error {
+		c <- 1
+		<-release
+		return nil
+	}))
+
+	finished := make(chan int, 100)
+	go gs.StartShutdown(SMFinishFunc(func() error {
+		finished <- 1
+		return nil
+	}))
+	<-c
+
+	returned := make(chan struct{})
+	go func() {
+		gs.StartShutdown(SMFinishFunc(func() error {
+			finished <- 2
+			return nil
+		}))
+		close(returned)
+	}()
+
+	select {
+	case <-returned:
+		t.Error("Expected second StartShutdown to wait for shutdown to finish.")
+	case <-time.After(time.Millisecond * 20):
+	}
+
+	close(release)
+	<-returned
+
+	if len(c) != 0 {
+		t.Error("Expected callbacks to run once, got", len(c)+1, "runs")
+	}
+	if len(finished) != 1 || <-finished != 1 {
+		t.Error("Expected ShutdownFinish only of the first ShutdownManager.")
+	}
+}
+
+func TestEventsReported(t *testing.T) {
+	c := make(chan Event, 100)
+	gs := New()
+
+	gs.SetEventHandler(EventFunc(func(event Event) {
+		c <- event
+	}))
+
+	gs.AddShutdownCallback(ShutdownFunc(func(string) error {
+		return errors.New("my-error")
+	}))
+
+	gs.StartShutdown(SMFinishFunc(func() error {
+		return nil
+	}))
+
+	expected := []Event{
+		{Type: EventShutdownRequested},
+		{Type: EventPhaseStarted, Phase: PhaseShutdownStart},
+		{Type: EventPhaseFinished, Phase: PhaseShutdownStart},
+		{Type: EventPhaseStarted, Phase: PhaseCallbacks},
+		{Type: EventCallbackStarted, Phase: PhaseCallbacks},
+		{Type: EventCallbackFinished, Phase: PhaseCallbacks},
+		{Type: EventPhaseFinished, Phase: PhaseCallbacks},
+		{Type: EventPhaseStarted, Phase: PhaseShutdownFinish},
+		{Type: EventPhaseFinished, Phase: PhaseShutdownFinish},
+	}
+
+	if len(c) != len(expected) {
+		t.Fatal("Expected", len(expected), "events, got", len(c))
+	}
+
+	for _, e := range expected {
+		event := <-c
+		if event.Type != e.Type || event.Phase != e.Phase || event.Manager != "test-sm" {
+			t.Error("Expected", e.Type, e.Phase, "got", event.Type, event.Phase, event.Manager)
+		}
+		if event.Type == EventCallbackFinished {
+			if event.Callback != "gracefulshutdown.ShutdownFunc#0" {
+				t.Error("Unexpected callback", event.Callback)
+			}
+			if event.Err == nil || event.Err.Error() != "my-error" {
+				t.Error("Expected callback error, got", event.Err)
+			}
+		}
+	}
+}
+
+func TestErrorContext(t *testing.T) {
+	c := make(chan error, 100)
+	gs := New()
+
+	gs.SetErrorHandler(ErrorFunc(func(err error) {
+		c <- err
+	}))
+
+	gs.AddShutdownCallback(ShutdownFunc(func(string) error {
+		return errors.New("callback-error")
+	}))
+
+	gs.StartShutdown(SMShutdownStartFunc(func() error {
+		return NewError("", "flush", SeverityCritical, errors.New("start-error"))
+	}))
+
+	if len(c) != 2 {
+		t.Fatal("Expected 2 errors, got", len(c))
+	}
+
+	var gsErr *Error
+	if err := <-c; !errors.As(err, &gsErr) || err.Error() != "start-error" {
+		t.Fatal("Expected Error, got", err)
+	}
+	if gsErr.Source != "test-sm" || gsErr.Phase != PhaseShutdownStart || gsErr.Action != "flush" || gsErr.Severity != SeverityCritical {
+		t.Error("Unexpected ShutdownStart error", *gsErr)
+	}
+
+	if err := <-c; !errors.As(err, &gsErr) {
+		t.Fatal("Expected Error, got", err)
+	}
+	if gsErr.Source != "gracefulshutdown.ShutdownFunc#0" || gsErr.Phase != PhaseCallbacks || gsErr.Severity != SeverityError {
+		t.Error("Unexpected callback error", *gsErr)
+	}
+
+	if NewError("test-sm", "", SeverityError, nil) != nil {
+		t.Error("Expected nil for nil error.")
+	}
+}
+
+func TestMultipleErrorHandlers(t *testing.T) {
+	c := make(chan int, 100)
+	gs := New()
+
+	gs.AddErrorHandler(ErrorFunc(func(err error) {
+		c <- 1
+	}))
+	gs.AddErrorHandler(ErrorFunc(func(err error) {
+		c <- 2
+	}))
+
+	gs.ReportError(errors.New("my-error"))
+
+	if len(c) != 2 || <-c != 1 || <-c != 2 {
+		t.Error("Expected error to be reported to both handlers in order.")
+	}
+
+	gs.SetErrorHandler(nil)
+	gs.ReportError(errors.New("my-error"))
+
+	if len(c) != 0 {
+		t.Error("Expected SetErrorHandler to replace all handlers.")
+	}
+}
+
+func TestStartRollsBack(t *testing.T) {
+	c := make(chan int, 100)
+	errs := make(chan error, 100)
+	gs := New()
+
+	gs.SetErrorHandler(ErrorFunc(func(err error) {
+		errs <- err
+	}))
+
+	gs.AddShutdownManager(SMStopper{
+		SMStartFunc: func() error {
+			return nil
+		},
+		stop: func() error {
+			c <- 1
+			return errors.New("stop-error")
+		},
+	})
+	gs.AddShutdownManager(SMStopper{
+		SMStartFunc: func() error {
+			gs.AddShutdownCallback(ShutdownFunc(func(string) error {
+				return nil
+			}))
+			return nil
+		},
+		stop: func() error {
+			c <- 2
+			return nil
+		},
+	})
+	gs.AddShutdownManager(SMStartFunc(func() error {
+		return errors.New("start-error")
+	}))
+	gs.AddShutdownManager(SMStopper{
+		SMStartFunc: func() error {
+			t.Error("Expected manager after failed one not to be started.")
+			return nil
+		},
+		stop: func() error {
+			t.Error("Expected manager after failed one not to be stopped.")
+			return nil
+		},
+	})
+
+	if err := gs.Start(); err == nil || err.Error() != "start-error" {
+		t.Error("Expected start-error, got", err)
+	}
+
+	if len(c) != 2 || <-c != 2 || <-c != 1 {
+		t.Error("Expected started managers to be stopped in reverse order.")
+	}
+
+	if len(gs.callbacks) != 0 {
+		t.Error("Expected callbacks added in Start to be removed, got", len(gs.callbacks))
+	}
+
+	if len(errs) != 1 || (<-errs).Error() != "stop-error" {
+		t.Error("Expected stop-error to be reported.")
+	}
+}
+
+type SMHealth struct {
+	SMStartFunc
+	health error
+}
+
+func (sm SMHealth) Health() error {
+	return sm.health
+}
+
+func TestHealth(t *testing.T) {
+	gs := New()
+
+	gs.AddShutdownManager(SMStartFunc(func() error {
+		return nil
+	}))
+	gs.AddShutdownManager(SMHealth{})
+
+	if err := gs.Health(); err != nil {
+		t.Error("Expected healthy, got", err)
+	}
+
+	gs.AddShutdownManager(SMHealth{health: errors.New("listener-dead")})
+
+	err := gs.Health()
+	var gsErr *Error
+	if !errors.As(err, &gsErr) || err.Error() != "listener-dead" {
+		t.Fatal("Expected listener-dead, got", err)
+	}
+	if gsErr.Source != "test-sm" || gsErr.Action != "health" {
+		t.Error("Unexpected health error", *gsErr)
+	}
+}
+
+func TestPhasesRunInOrder(t *testing.T) {
+	c := make(chan string, 100)
+	gs := New()
+
+	callback := func(name string) ShutdownCallback {
+		return ShutdownFunc(func(string) error {
+			c <- name
+			return nil
+		})
+	}
+
+	gs.AddPhaseCallback("close", callback("close"))
+	gs.AddPhaseCallback("flush", callback("flush"))
+	gs.AddPhaseCallback("drain", callback("drain"))
+	gs.AddShutdownCallback(callback("default"))
+	gs.SetPhase("drain", 0)
+	gs.SetPhase("close", 0)
+
+	gs.StartShutdown(SMFinishFunc(func() error {
+		c <- "finish"
+		return nil
+	}))
+
+	for _, expected := range []string{"default", "drain", "close", "flush", "finish"} {
+		if name := <-c; name != expected {
+			t.Error("Expected", expected, "got", name)
+		}
+	}
+}
+
+func TestPhaseTimeout(t *testing.T) {
+	errs := make(chan error, 100)
+	c := make(chan string, 100)
+	gs := New()
+
+	gs.SetErrorHandler(ErrorFunc(func(err error) {
+		errs <- err
+	}))
+
+	block := make(chan struct{})
+	defer close(block)
+
+	gs.SetPhase("drain", time.Millisecond*20)
+	gs.AddPhaseCallback("drain", ShutdownFunc(func(string) error {
+		<-block
+		return nil
+	}))
+	gs.AddPhaseCallback("close", ShutdownFunc(func(string) error {
+		c <- "close"
+		return nil
+	}))
+
+	start := time.Now()
+	gs.StartShutdown(SMFinishFunc(func() error {
+		return nil
+	}))
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("Expected shutdown not to wait for blocked callback, took", elapsed)
+	}
+
+	if len(c) != 1 {
+		t.Error("Expected next phase to run after timeout.")
+	}
+
+	var gsErr *Error
+	if len(errs) != 1 || !errors.As(<-errs, &gsErr) || gsErr.Err != ErrTimeout || gsErr.Phase != "drain" || gsErr.Action != "timeout" {
+		t.Error("Expected timeout error for drain phase.")
+	}
+}
+
+func TestTimeoutSkipsPhases(t *testing.T) {
+	errs := make(chan error, 100)
+	gs := New()
+
+	gs.SetErrorHandler(ErrorFunc(func(err error) {
+		errs <- err
+	}))
+
+	block := make(chan struct{})
+	defer close(block)
+
+	gs.SetTimeout(time.Millisecond * 20)
+	gs.AddShutdownCallback(ShutdownFunc(func(string) error {
+		<-block
+		return nil
+	}))
+	gs.AddPhaseCallback("close", ShutdownFunc(func(string) error {
+		t.Error("Expected close phase to be skipped after timeout.")
+		return nil
+	}))
+
+	gs.StartShutdown(SMFinishFunc(func() error {
+		return nil
+	}))
+
+	var actions []string
+	for len(errs) > 0 {
+		var gsErr *Error
+		if errors.As(<-errs, &gsErr) && gsErr.Err == ErrTimeout {
+			actions = append(actions, gsErr.Action)
+		}
+	}
+	if len(actions) != 2 || actions[0] != "timeout" || actions[1] != "skip" {
+		t.Error("Expected timeout and skip errors, got", actions)
+	}
+}
+
+func TestExitPolicy(t *testing.T) {
+	c := make(chan int, 100)
+	exit = func(code int) {
+		c <- code
+	}
+	defer func() {
+		exit = os.Exit
+	}()
+
+	gs := New()
+	gs.SetExitPolicy(&ExitPolicy{Code: 3, TimeoutCode: 4})
+	gs.StartShutdown(SMFinishFunc(func() error {
+		select {
+		case <-c:
+			t.Error("Expected exit after ShutdownFinish.")
+		default:
+		}
+		return nil
+	}))
+
+	if len(c) != 1 || <-c != 3 {
+		t.Error("Expected exit with code 3.")
+	}
+
+	block := make(chan struct{})
+	defer close(block)
+
+	gs = New()
+	gs.SetExitPolicy(&ExitPolicy{Code: 3, TimeoutCode: 4})
+	gs.SetTimeout(time.Millisecond)
+	gs.AddShutdownCallback(ShutdownFunc(func(string) error {
+		<-block
+		return nil
+	}))
+	gs.StartShutdown(SMFinishFunc(func() error {
+		return nil
+	}))
+
+	if len(c) != 1 || <-c != 4 {
+		t.Error("Expected exit with timeout code 4.")
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Package testutil provides helpers shared by tests of ShutdownManagers.
+*/
+package testutil
+
+import (
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Goroutines returns the number of goroutines, to be passed to
+// WaitGoroutines. The first signal.Notify starts a goroutine of os/signal
+// that never exits, so it is started before goroutines are counted.
+func Goroutines() int {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	signal.Stop(interrupt)
+
+	return runtime.NumGoroutine()
+}
+
+// WaitGoroutines waits for the number of goroutines to drop to n,
+// so goroutines started by the test are known to have exited.
+func WaitGoroutines(t testing.TB, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Error("Expected goroutines to exit, got", runtime.NumGoroutine()-n, "more")
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// WaitListening waits until a tcp listener accepts connections on addr.
+func WaitListening(t testing.TB, addr string) {
+	t.Helper()
+
+	var err error
+	for i := 0; i < 100; i++ {
+		var conn net.Conn
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatal("Listener not started:", err)
+}
@@ -0,0 +1,72 @@
+package gracefulshutdown_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/shutdownmanagers/filemanager"
+)
+
+// failOnceManager fails its first Start, so GracefulShutdown rolls back
+// managers started before it.
+type failOnceManager struct {
+	starts int
+}
+
+func (failOnceManager *failOnceManager) GetName() string {
+	return "fail-once"
+}
+
+func (failOnceManager *failOnceManager) Start(gs gracefulshutdown.GSInterface) error {
+	failOnceManager.starts++
+	if failOnceManager.starts == 1 {
+		return errors.New("not yet")
+	}
+	return nil
+}
+
+func (failOnceManager *failOnceManager) ShutdownStart() error {
+	return nil
+}
+
+func (failOnceManager *failOnceManager) ShutdownFinish() error {
+	return nil
+}
+
+func TestStartRetryAfterRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gracefulshutdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "shutdown")
+
+	gs := gracefulshutdown.New()
+	gs.AddShutdownManager(filemanager.NewFileManager(&filemanager.FileManagerConfig{
+		Path:         path,
+		PollInterval: time.Millisecond * 5,
+	}))
+	gs.AddShutdownManager(&failOnceManager{})
+
+	if err := gs.Start(); err == nil {
+		t.Error("Expected error from first start.")
+	}
+	if err := gs.Start(); err != nil {
+		t.Error("Expected second start to succeed, got", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("retried"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-gs.Done():
+	case <-time.After(time.Second):
+		t.Error("Expected FileManager to start shutdown after retried Start.")
+	}
+}
@@ -9,12 +9,14 @@ package awsmanager
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Zemanta/gracefulshutdown"
@@ -30,6 +32,11 @@ const (
 	defaultBackOff        = 500.0
 	defaultForwardRetries = 10
 	defaultServeRetries   = 20
+
+	// maxReceiveBackOffTries caps the back off between failed sqs receives
+	maxReceiveBackOffTries = 20
+
+	defaultUnhealthyReceiveFailures = 3
 )
 
 // AwsManager implements ShutdownManager interface that is added
@@ -41,6 +48,13 @@ type AwsManager struct {
 	api      awsApiInterface
 	listener net.Listener
 
+	mutex           sync.Mutex
+	stop            chan struct{}
+	stopOnce        sync.Once
+	httpErr         error
+	receiveErr      error
+	receiveFailures int
+
 	lifecycleActionToken string
 	autoscalingGroupName string
 }
@@ -89,6 +103,10 @@ type AwsManagerConfig struct {
 	// -1 for no retries, 0 is default value
 	NumForwardRetries int
 
+	// UnhealthyReceiveFailures is number of consecutive failed sqs receives
+	// after which Health returns an error. Default is 3.
+	UnhealthyReceiveFailures int
+
 	// Region and InstanceId are optional. If empty they get collected from
 	// EC2 instance metadata.
 	Region     string
@@ -117,6 +135,9 @@ func (amc *AwsManagerConfig) clean() {
 	} else if amc.NumServeRetries < 0 {
 		amc.NumServeRetries = 0
 	}
+	if amc.UnhealthyReceiveFailures == 0 {
+		amc.UnhealthyReceiveFailures = defaultUnhealthyReceiveFailures
+	}
 	if amc.NumForwardRetries == 0 {
 		amc.NumForwardRetries = defaultForwardRetries
 	} else if amc.NumForwardRetries < 0 {
@@ -170,12 +191,18 @@ func (awsManager *AwsManager) Start(gs gracefulshutdown.GSInterface) error {
 
 	awsManager.gs.AddShutdownCallback(awsManager)
 
+	awsManager.mutex.Lock()
+	stop := make(chan struct{})
+	awsManager.stop = stop
+	awsManager.stopOnce = sync.Once{}
+	awsManager.mutex.Unlock()
+
 	if awsManager.config.SqsQueueName != "" {
-		go awsManager.listenSQS()
+		go awsManager.listenSQS(stop)
 	}
 
 	if awsManager.config.Port != 0 {
-		go awsManager.listenHTTP()
+		go awsManager.listenHTTP(stop)
 	}
 
 	return nil
@@ -183,12 +210,51 @@ func (awsManager *AwsManager) Start(gs gracefulshutdown.GSInterface) error {
 
 // OnShutdown closes http server on shutdown
 func (awsManager *AwsManager) OnShutdown(shutdownManager string) error {
+	awsManager.mutex.Lock()
+	defer awsManager.mutex.Unlock()
+
 	if awsManager.listener != nil {
 		awsManager.listener.Close()
+		awsManager.listener = nil
 	}
 	return nil
 }
 
+// Stop stops listening to sqs queue and closes http server.
+func (awsManager *AwsManager) Stop() error {
+	awsManager.mutex.Lock()
+	defer awsManager.mutex.Unlock()
+
+	awsManager.stopOnce.Do(func() {
+		if awsManager.stop != nil {
+			close(awsManager.stop)
+		}
+	})
+	if awsManager.listener != nil {
+		err := awsManager.listener.Close()
+		awsManager.listener = nil
+		return err
+	}
+	return nil
+}
+
+// Health returns an error if http listener failed or if receiving from
+// sqs failed UnhealthyReceiveFailures times in a row, so termination
+// messages could be missed.
+func (awsManager *AwsManager) Health() error {
+	awsManager.mutex.Lock()
+	defer awsManager.mutex.Unlock()
+
+	var errs []error
+	if awsManager.httpErr != nil {
+		errs = append(errs, fmt.Errorf("http listener failed: %w", awsManager.httpErr))
+	}
+	if awsManager.receiveFailures >= awsManager.config.UnhealthyReceiveFailures {
+		errs = append(errs, fmt.Errorf("sqs receive failed %d times: %w", awsManager.receiveFailures, awsManager.receiveErr))
+	}
+	return errors.Join(errs...)
+}
+
 // ServeHTTP is used for receiving messages over http.
 func (awsManager *AwsManager) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	bs, err := ioutil.ReadAll(req.Body)
@@ -205,29 +271,87 @@ func (awsManager *AwsManager) ServeHTTP(w http.ResponseWriter, req *http.Request
 	}
 }
 
-func (awsManager *AwsManager) listenHTTP() {
+func (awsManager *AwsManager) listenHTTP(stop <-chan struct{}) {
+	var listener net.Listener
 	var err error
 
 	for i := 0; i < awsManager.config.NumServeRetries+1; i++ {
-		awsManager.listener, err = net.Listen("tcp", fmt.Sprintf(":%d", awsManager.config.Port))
+		listener, err = net.Listen("tcp", fmt.Sprintf(":%d", awsManager.config.Port))
 		if err == nil {
 			break
 		}
 
-		time.Sleep(awsManager.backOffDuration(i))
+		select {
+		case <-time.After(awsManager.backOffDuration(i)):
+		case <-stop:
+			return
+		}
 	}
 	if err != nil {
-		awsManager.gs.ReportError(err)
+		awsManager.mutex.Lock()
+		awsManager.httpErr = err
+		awsManager.mutex.Unlock()
+
+		awsManager.gs.ReportError(gracefulshutdown.NewError(Name, "listen", gracefulshutdown.SeverityCritical, err))
 		return
 	}
 
-	http.Serve(awsManager.listener, awsManager)
+	awsManager.mutex.Lock()
+	select {
+	case <-stop:
+		awsManager.mutex.Unlock()
+		listener.Close()
+		return
+	default:
+	}
+	awsManager.listener = listener
+	awsManager.mutex.Unlock()
+
+	err = http.Serve(listener, awsManager)
+
+	// listener is set to nil when it is closed on shutdown or Stop
+	awsManager.mutex.Lock()
+	if awsManager.listener == listener {
+		awsManager.httpErr = err
+	}
+	awsManager.mutex.Unlock()
 }
 
-func (awsManager *AwsManager) listenSQS() {
+func (awsManager *AwsManager) listenSQS(stop <-chan struct{}) {
 	for {
 		message, err := awsManager.api.ReceiveMessage()
-		awsManager.gs.ReportError(err)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		awsManager.mutex.Lock()
+		failures := 0
+		if err != nil {
+			awsManager.receiveErr = err
+			awsManager.receiveFailures++
+			failures = awsManager.receiveFailures
+		} else {
+			awsManager.receiveFailures = 0
+		}
+		awsManager.mutex.Unlock()
+
+		if err != nil {
+			awsManager.gs.ReportError(gracefulshutdown.NewError(Name, "receive", gracefulshutdown.SeverityWarning, err))
+
+			// back off instead of retrying in a tight loop
+			if failures > maxReceiveBackOffTries {
+				failures = maxReceiveBackOffTries
+			}
+			select {
+			case <-time.After(awsManager.backOffDuration(failures)):
+			case <-stop:
+				return
+			}
+			continue
+		}
 
 		if message == nil {
 			continue
@@ -264,9 +388,13 @@ func (awsManager *AwsManager) handleMessage(message string) bool {
 		go awsManager.gs.StartShutdown(awsManager)
 		return true
 	} else if awsManager.config.Port != 0 {
-		if err := awsManager.forwardMessage(hookMessage, message); err != nil {
-			awsManager.gs.ReportError(err)
-		}
+		start := time.Now()
+		err := awsManager.forwardMessage(hookMessage, message)
+		awsManager.reportEvent("forward", start, err, map[string]interface{}{
+			"instanceId":           hookMessage.EC2InstanceId,
+			"autoScalingGroupName": hookMessage.AutoScalingGroupName,
+		})
+		awsManager.gs.ReportError(gracefulshutdown.NewError(Name, "forward", gracefulshutdown.SeverityError, err))
 		return true
 	}
 	return false
@@ -300,10 +428,13 @@ func (awsManager *AwsManager) ShutdownStart() error {
 	awsManager.ticker = time.NewTicker(awsManager.config.PingTime)
 	go func() {
 		for {
-			awsManager.gs.ReportError(awsManager.api.SendHeartbeat(
+			start := time.Now()
+			err := awsManager.api.SendHeartbeat(
 				awsManager.autoscalingGroupName,
 				awsManager.lifecycleActionToken,
-			))
+			)
+			awsManager.reportEvent("heartbeat", start, err, nil)
+			awsManager.gs.ReportError(gracefulshutdown.NewError(Name, "heartbeat", gracefulshutdown.SeverityError, err))
 			<-awsManager.ticker.C
 		}
 	}()
@@ -315,8 +446,33 @@ func (awsManager *AwsManager) ShutdownStart() error {
 func (awsManager *AwsManager) ShutdownFinish() error {
 	awsManager.ticker.Stop()
 
-	return awsManager.api.CompleteLifecycleAction(
+	start := time.Now()
+	err := awsManager.api.CompleteLifecycleAction(
 		awsManager.autoscalingGroupName,
 		awsManager.lifecycleActionToken,
 	)
+	awsManager.reportEvent("complete", start, err, nil)
+	return gracefulshutdown.NewError(Name, "complete", gracefulshutdown.SeverityCritical, err)
+}
+
+// reportEvent reports an EventManager if gs implements EventReporter.
+func (awsManager *AwsManager) reportEvent(action string, start time.Time, err error, fields map[string]interface{}) {
+	eventReporter, ok := awsManager.gs.(gracefulshutdown.EventReporter)
+	if !ok {
+		return
+	}
+	if fields == nil {
+		fields = map[string]interface{}{
+			"autoScalingGroupName": awsManager.autoscalingGroupName,
+		}
+	}
+
+	eventReporter.ReportEvent(gracefulshutdown.Event{
+		Type:     gracefulshutdown.EventManager,
+		Manager:  Name,
+		Action:   action,
+		Fields:   fields,
+		Duration: time.Since(start),
+		Err:      err,
+	})
 }
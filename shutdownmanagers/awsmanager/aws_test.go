@@ -1,6 +1,8 @@
 package awsmanager
 
 import (
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
@@ -9,6 +11,7 @@ import (
 	"time"
 
 	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/internal/testutil"
 
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
@@ -135,7 +138,9 @@ func TestListenSqs(t *testing.T) {
 	mock := newAwsApiMock()
 	awsManager.api = mock
 
-	go awsManager.listenSQS()
+	stop := make(chan struct{})
+	defer close(stop)
+	go awsManager.listenSQS(stop)
 	time.Sleep(time.Millisecond * 5)
 
 	if len(mock.deleteChannel) != 1 {
@@ -345,3 +350,167 @@ func TestOtherTransition(t *testing.T) {
 		t.Error("Should detect instance is not terminating.")
 	}
 }
+
+type eventGSMock struct {
+	GSFunc
+	events chan gracefulshutdown.Event
+}
+
+func (gs *eventGSMock) ReportEvent(event gracefulshutdown.Event) {
+	gs.events <- event
+}
+
+func TestEventsReported(t *testing.T) {
+	awsManager := NewAwsManager(&AwsManagerConfig{
+		PingTime: time.Hour,
+	})
+	gs := &eventGSMock{
+		GSFunc: GSFunc(func(sm gracefulshutdown.ShutdownManager) {}),
+		events: make(chan gracefulshutdown.Event, 100),
+	}
+	awsManager.gs = gs
+	awsManager.api = newAwsApiMock()
+	awsManager.autoscalingGroupName = "my-autoscaling-group"
+
+	awsManager.ShutdownStart()
+	time.Sleep(time.Millisecond)
+	awsManager.ShutdownFinish()
+
+	for _, action := range []string{"heartbeat", "complete"} {
+		event := <-gs.events
+		if event.Type != gracefulshutdown.EventManager || event.Manager != Name || event.Action != action {
+			t.Error("Expected", action, "event, got", event)
+		}
+		if event.Fields["autoScalingGroupName"] != "my-autoscaling-group" {
+			t.Error("Expected autoScalingGroupName field, got", event.Fields)
+		}
+	}
+}
+
+type failingReceiveMock struct {
+	*awsApiMock
+}
+
+func (api failingReceiveMock) ReceiveMessage() (*sqs.Message, error) {
+	return nil, errors.New("receive-error")
+}
+
+func TestHealth(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen:", err)
+	}
+	defer listener.Close()
+
+	awsManager := NewAwsManager(&AwsManagerConfig{
+		Port:            uint16(listener.Addr().(*net.TCPAddr).Port),
+		BackOff:         1,
+		NumServeRetries: -1,
+	})
+	awsManager.gs = GSFunc(func(sm gracefulshutdown.ShutdownManager) {})
+	awsManager.api = failingReceiveMock{newAwsApiMock()}
+
+	if err := awsManager.Health(); err != nil {
+		t.Error("Expected healthy, got", err)
+	}
+
+	stop := make(chan struct{})
+	awsManager.listenHTTP(stop)
+
+	err = awsManager.Health()
+	if err == nil || !strings.Contains(err.Error(), "http listener failed") {
+		t.Error("Expected http listener error, got", err)
+	}
+
+	go awsManager.listenSQS(stop)
+	time.Sleep(time.Millisecond * 100)
+	close(stop)
+
+	err = awsManager.Health()
+	if err == nil || !strings.Contains(err.Error(), "receive-error") {
+		t.Error("Expected receive error, got", err)
+	}
+}
+
+// blockingReceiveMock returns messages from receive, like a long poll
+// that returns when a message arrives.
+type blockingReceiveMock struct {
+	*awsApiMock
+	receive chan *sqs.Message
+}
+
+func (api blockingReceiveMock) ReceiveMessage() (*sqs.Message, error) {
+	return <-api.receive, nil
+}
+
+func TestStop(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen:", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	goroutines := testutil.Goroutines()
+
+	c := make(chan int, 100)
+	awsManager := NewAwsManager(&AwsManagerConfig{
+		SqsQueueName:      "my-queue",
+		LifecycleHookName: "my-lifecycle-hook",
+		InstanceId:        "i-1db84ae3",
+		Region:            "us-east-1",
+		Port:              uint16(port),
+	})
+	mock := blockingReceiveMock{newAwsApiMock(), make(chan *sqs.Message)}
+	awsManager.api = mock
+	if err := awsManager.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	})); err != nil {
+		t.Fatal("Error in start:", err)
+	}
+
+	addr := "127.0.0.1:" + strconv.Itoa(port)
+	testutil.WaitListening(t, addr)
+
+	if err := awsManager.Stop(); err != nil {
+		t.Error("Error in stop:", err)
+	}
+	if err := awsManager.Stop(); err != nil {
+		t.Error("Error in second stop:", err)
+	}
+
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("Expected listener to be closed after Stop.")
+	}
+
+	// receive that was in progress returns a termination message
+	msg := `{"AutoScalingGroupName":"my-autoscaling-group","Service":"AWS Auto Scaling","LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","LifecycleActionToken":"my-lifecycle-token","EC2InstanceId":"i-1db84ae3","LifecycleHookName":"my-lifecycle-hook"}`
+	mock.receive <- &sqs.Message{Body: &msg}
+
+	testutil.WaitGoroutines(t, goroutines)
+
+	if len(c) != 0 {
+		t.Error("Shutdown started after Stop.")
+	}
+
+	if len(mock.deleteChannel) != 0 {
+		t.Error("Message received after Stop was deleted.")
+	}
+
+	// Start after Stop listens on the same port and receives again
+	if err := awsManager.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	})); err != nil {
+		t.Fatal("Error in restart:", err)
+	}
+	defer awsManager.Stop()
+	testutil.WaitListening(t, addr)
+
+	mock.receive <- &sqs.Message{Body: &msg}
+	select {
+	case <-c:
+	case <-time.After(time.Second):
+		t.Error("Expected shutdown after restart.")
+	}
+}
@@ -0,0 +1,323 @@
+/*
+CgroupManager watches memory of the cgroup v2 the process runs in and
+starts shutdown before the OOM killer is invoked. It periodically reads
+memory.current, memory.max, memory.events and memory.pressure and starts
+shutdown when:
+  - memory.current exceeds MaxUsage fraction of memory.max,
+  - "some" or "full" avg10 memory pressure exceeds MaxPressureSome
+    or MaxPressureFull,
+  - the "max" counter in memory.events increases, if OnMaxEvents is set.
+
+The cgroup is read from /proc/self/cgroup, unless Path is given.
+
+CgroupManager does not exit the process. Use
+GracefulShutdown.SetExitPolicy, so the process frees its memory before
+the OOM killer has to once shutdown finishes.
+*/
+package cgroupmanager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+const (
+	Name = "CgroupManager"
+
+	defaultRoot           = "/sys/fs/cgroup"
+	defaultSampleInterval = time.Second * 5
+)
+
+// ErrNoCgroup is returned from Start if cgroup v2 of the process can not
+// be found.
+var ErrNoCgroup = errors.New("cgroupmanager: cgroup v2 not found")
+
+// CgroupManager implements ShutdownManager interface that is added
+// to GracefulShutdown. Initialize with NewCgroupManager.
+type CgroupManager struct {
+	gs     gracefulshutdown.GSInterface
+	config *CgroupManagerConfig
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mutex  sync.Mutex
+	reason string
+}
+
+// CgroupManagerConfig provides configuration options for CgroupManager.
+// Limits that are 0 are not checked.
+type CgroupManagerConfig struct {
+	// Path of the cgroup directory. If empty, it is read from
+	// /proc/self/cgroup.
+	Path string
+
+	// MaxUsage is fraction of memory.max that memory.current
+	// can reach, for example 0.9.
+	MaxUsage float64
+
+	// MaxPressureSome is limit for "some avg10" memory pressure in percent.
+	MaxPressureSome float64
+
+	// MaxPressureFull is limit for "full avg10" memory pressure in percent.
+	MaxPressureFull float64
+
+	// OnMaxEvents starts shutdown when the cgroup hits memory.max.
+	OnMaxEvents bool
+
+	// SampleInterval is period for reading the cgroup files.
+	// Default is 5 seconds.
+	SampleInterval time.Duration
+}
+
+func (cmc *CgroupManagerConfig) clean() {
+	if cmc.SampleInterval == 0 {
+		cmc.SampleInterval = defaultSampleInterval
+	}
+}
+
+// NewCgroupManager initializes the CgroupManager. See CgroupManagerConfig for
+// configuration options.
+func NewCgroupManager(cgroupManagerConfig *CgroupManagerConfig) *CgroupManager {
+	if cgroupManagerConfig == nil {
+		cgroupManagerConfig = &CgroupManagerConfig{}
+	}
+	cgroupManagerConfig.clean()
+	return &CgroupManager{
+		config: cgroupManagerConfig,
+	}
+}
+
+// GetName returns name of this ShutdownManager.
+func (cgroupManager *CgroupManager) GetName() string {
+	return Name
+}
+
+// Start starts watching the cgroup. Will return error if the cgroup can
+// not be found or its memory files can not be read.
+func (cgroupManager *CgroupManager) Start(gs gracefulshutdown.GSInterface) error {
+	cgroupManager.gs = gs
+
+	if cgroupManager.config.Path == "" {
+		path, err := selfCgroup(defaultRoot, "/proc/self/cgroup")
+		if err != nil {
+			return err
+		}
+		cgroupManager.config.Path = path
+	}
+
+	if _, err := cgroupManager.readUint("memory.current"); err != nil {
+		return err
+	}
+
+	var maxEvents uint64
+	if cgroupManager.config.OnMaxEvents {
+		events, err := cgroupManager.readKeyed("memory.events")
+		if err != nil {
+			return err
+		}
+		maxEvents = uint64(events["max"])
+	}
+
+	stop := make(chan struct{})
+	cgroupManager.stop = stop
+	cgroupManager.stopOnce = sync.Once{}
+	go cgroupManager.watch(stop, maxEvents)
+
+	return nil
+}
+
+// Reason returns description of the condition that started shutdown.
+func (cgroupManager *CgroupManager) Reason() string {
+	cgroupManager.mutex.Lock()
+	defer cgroupManager.mutex.Unlock()
+	return cgroupManager.reason
+}
+
+func (cgroupManager *CgroupManager) watch(stop <-chan struct{}, maxEvents uint64) {
+	ticker := time.NewTicker(cgroupManager.config.SampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		reason, err := cgroupManager.check(maxEvents)
+		if err != nil {
+			cgroupManager.gs.ReportError(gracefulshutdown.NewError(Name, "sample", gracefulshutdown.SeverityWarning, err))
+			continue
+		}
+		if reason == "" {
+			continue
+		}
+
+		cgroupManager.mutex.Lock()
+		cgroupManager.reason = reason
+		cgroupManager.mutex.Unlock()
+
+		cgroupManager.gs.StartShutdown(cgroupManager)
+		return
+	}
+}
+
+// check returns description of exceeded limit, or empty string.
+// maxEvents is the "max" counter in memory.events when Start was called.
+func (cgroupManager *CgroupManager) check(maxEvents uint64) (string, error) {
+	config := cgroupManager.config
+
+	if config.MaxUsage > 0 {
+		current, err := cgroupManager.readUint("memory.current")
+		if err != nil {
+			return "", err
+		}
+		max, err := cgroupManager.readUint("memory.max")
+		if err != nil {
+			return "", err
+		}
+		if max > 0 && float64(current) > config.MaxUsage*float64(max) {
+			return fmt.Sprintf("memory.current %d exceeded %.2f of memory.max %d", current, config.MaxUsage, max), nil
+		}
+	}
+
+	if config.OnMaxEvents {
+		events, err := cgroupManager.readKeyed("memory.events")
+		if err != nil {
+			return "", err
+		}
+		if uint64(events["max"]) > maxEvents {
+			return fmt.Sprintf("memory.events max increased to %d", uint64(events["max"])), nil
+		}
+	}
+
+	if config.MaxPressureSome > 0 || config.MaxPressureFull > 0 {
+		pressure, err := cgroupManager.readPressure()
+		if err != nil {
+			return "", err
+		}
+		if config.MaxPressureSome > 0 && pressure["some"] > config.MaxPressureSome {
+			return fmt.Sprintf("memory.pressure some avg10 %.2f exceeded %.2f", pressure["some"], config.MaxPressureSome), nil
+		}
+		if config.MaxPressureFull > 0 && pressure["full"] > config.MaxPressureFull {
+			return fmt.Sprintf("memory.pressure full avg10 %.2f exceeded %.2f", pressure["full"], config.MaxPressureFull), nil
+		}
+	}
+
+	return "", nil
+}
+
+// readUint reads a single value file. "max" is returned as 0.
+func (cgroupManager *CgroupManager) readUint(name string) (uint64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(cgroupManager.config.Path, name))
+	if err != nil {
+		return 0, err
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// readKeyed reads a flat keyed file like memory.events.
+func (cgroupManager *CgroupManager) readKeyed(name string) (map[string]float64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(cgroupManager.config.Path, name))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]float64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("cgroupmanager: invalid %s line %q", name, line)
+		}
+		values[fields[0]] = value
+	}
+	return values, nil
+}
+
+// readPressure reads avg10 of "some" and "full" lines of memory.pressure.
+func (cgroupManager *CgroupManager) readPressure() (map[string]float64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(cgroupManager.config.Path, "memory.pressure"))
+	if err != nil {
+		return nil, err
+	}
+
+	pressure := make(map[string]float64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "avg10=") {
+				continue
+			}
+			value, err := strconv.ParseFloat(strings.TrimPrefix(field, "avg10="), 64)
+			if err != nil {
+				return nil, fmt.Errorf("cgroupmanager: invalid memory.pressure line %q", line)
+			}
+			pressure[fields[0]] = value
+		}
+	}
+	return pressure, nil
+}
+
+// selfCgroup returns directory of cgroup v2 from the unified hierarchy
+// entry "0::/path" in cgroupFile.
+func selfCgroup(root, cgroupFile string) (string, error) {
+	file, err := os.Open(cgroupFile)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if path := strings.TrimPrefix(scanner.Text(), "0::"); path != scanner.Text() {
+			return filepath.Join(root, path), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", ErrNoCgroup
+}
+
+// Stop stops sampling the cgroup.
+func (cgroupManager *CgroupManager) Stop() error {
+	cgroupManager.stopOnce.Do(func() {
+		if cgroupManager.stop != nil {
+			close(cgroupManager.stop)
+		}
+	})
+	return nil
+}
+
+// ShutdownStart does nothing.
+func (cgroupManager *CgroupManager) ShutdownStart() error {
+	return nil
+}
+
+// ShutdownFinish does nothing.
+func (cgroupManager *CgroupManager) ShutdownFinish() error {
+	return nil
+}
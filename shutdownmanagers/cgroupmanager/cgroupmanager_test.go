@@ -0,0 +1,216 @@
+package cgroupmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/internal/testutil"
+)
+
+type GSFunc func(sm gracefulshutdown.ShutdownManager)
+
+func (f GSFunc) StartShutdown(sm gracefulshutdown.ShutdownManager) {
+	f(sm)
+}
+
+func (f GSFunc) ReportError(err error) {
+
+}
+
+func (f GSFunc) AddShutdownCallback(shutdownCallback gracefulshutdown.ShutdownCallback) {
+
+}
+
+const (
+	pressureLow  = "some avg10=1.00 avg60=0.50 avg300=0.10 total=1000\nfull avg10=0.50 avg60=0.20 avg300=0.05 total=500\n"
+	pressureHigh = "some avg10=45.00 avg60=10.00 avg300=2.00 total=90000\nfull avg10=30.00 avg60=5.00 avg300=1.00 total=50000\n"
+	eventsNone   = "low 0\nhigh 0\nmax 0\noom 0\noom_kill 0\n"
+	eventsMax    = "low 0\nhigh 0\nmax 3\noom 0\noom_kill 0\n"
+)
+
+// fakeCgroup creates a cgroup directory with memory files.
+func fakeCgroup(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cgroupmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write(t, dir, "memory.current", "100\n")
+	write(t, dir, "memory.max", "1000\n")
+	write(t, dir, "memory.events", eventsNone)
+	write(t, dir, "memory.pressure", pressureLow)
+	return dir
+}
+
+func write(t *testing.T, dir, name, contents string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func startManager(t *testing.T, config *CgroupManagerConfig) (*CgroupManager, chan int) {
+	c := make(chan int, 100)
+	config.SampleInterval = time.Millisecond
+	cm := NewCgroupManager(config)
+	if err := cm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	})); err != nil {
+		t.Fatal("Error in start:", err)
+	}
+	return cm, c
+}
+
+func waitShutdown(t *testing.T, c <-chan int) {
+	select {
+	case <-c:
+
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for StartShutdown.")
+	}
+}
+
+func TestUsage(t *testing.T) {
+	dir := fakeCgroup(t)
+	defer os.RemoveAll(dir)
+
+	cm, c := startManager(t, &CgroupManagerConfig{
+		Path:     dir,
+		MaxUsage: 0.9,
+	})
+
+	time.Sleep(time.Millisecond * 10)
+	if len(c) != 0 {
+		t.Fatal("Shutdown started below limit.")
+	}
+
+	write(t, dir, "memory.current", "950\n")
+	waitShutdown(t, c)
+
+	if !strings.Contains(cm.Reason(), "memory.current 950") {
+		t.Error("Unexpected reason:", cm.Reason())
+	}
+}
+
+func TestUsageNoLimit(t *testing.T) {
+	dir := fakeCgroup(t)
+	defer os.RemoveAll(dir)
+
+	write(t, dir, "memory.max", "max\n")
+	write(t, dir, "memory.current", "100000\n")
+
+	_, c := startManager(t, &CgroupManagerConfig{
+		Path:     dir,
+		MaxUsage: 0.9,
+	})
+
+	time.Sleep(time.Millisecond * 10)
+	if len(c) != 0 {
+		t.Error("Shutdown started without memory.max.")
+	}
+}
+
+func TestPressure(t *testing.T) {
+	dir := fakeCgroup(t)
+	defer os.RemoveAll(dir)
+
+	cm, c := startManager(t, &CgroupManagerConfig{
+		Path:            dir,
+		MaxPressureFull: 20,
+	})
+
+	time.Sleep(time.Millisecond * 10)
+	if len(c) != 0 {
+		t.Fatal("Shutdown started below pressure limit.")
+	}
+
+	write(t, dir, "memory.pressure", pressureHigh)
+	waitShutdown(t, c)
+
+	if !strings.Contains(cm.Reason(), "full avg10 30.00") {
+		t.Error("Unexpected reason:", cm.Reason())
+	}
+}
+
+func TestMaxEvents(t *testing.T) {
+	dir := fakeCgroup(t)
+	defer os.RemoveAll(dir)
+
+	write(t, dir, "memory.events", "low 0\nhigh 0\nmax 1\noom 0\noom_kill 0\n")
+
+	cm, c := startManager(t, &CgroupManagerConfig{
+		Path:        dir,
+		OnMaxEvents: true,
+	})
+
+	time.Sleep(time.Millisecond * 10)
+	if len(c) != 0 {
+		t.Fatal("Shutdown started on events before start.")
+	}
+
+	write(t, dir, "memory.events", eventsMax)
+	waitShutdown(t, c)
+
+	if !strings.Contains(cm.Reason(), "max increased to 3") {
+		t.Error("Unexpected reason:", cm.Reason())
+	}
+}
+
+func TestStartMissingCgroup(t *testing.T) {
+	cm := NewCgroupManager(&CgroupManagerConfig{
+		Path: filepath.Join(os.TempDir(), "does-not-exist"),
+	})
+
+	if err := cm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {})); err == nil {
+		t.Error("Expected error for missing cgroup.")
+	}
+}
+
+func TestSelfCgroup(t *testing.T) {
+	dir := fakeCgroup(t)
+	defer os.RemoveAll(dir)
+
+	write(t, dir, "cgroup", "0::/system.slice/my.service\n")
+	path, err := selfCgroup("/sys/fs/cgroup", filepath.Join(dir, "cgroup"))
+	if err != nil || path != "/sys/fs/cgroup/system.slice/my.service" {
+		t.Error("Unexpected cgroup path", path, err)
+	}
+
+	write(t, dir, "cgroup", "12:memory:/docker/abc\n")
+	if _, err := selfCgroup("/sys/fs/cgroup", filepath.Join(dir, "cgroup")); err != ErrNoCgroup {
+		t.Error("Expected ErrNoCgroup for cgroup v1, got", err)
+	}
+}
+
+func TestStop(t *testing.T) {
+	dir := fakeCgroup(t)
+	defer os.RemoveAll(dir)
+
+	goroutines := testutil.Goroutines()
+	cm, c := startManager(t, &CgroupManagerConfig{
+		Path:     dir,
+		MaxUsage: 0.9,
+	})
+
+	if err := cm.Stop(); err != nil {
+		t.Error("Error in stop:", err)
+	}
+	cm.Stop()
+	testutil.WaitGoroutines(t, goroutines)
+
+	write(t, dir, "memory.current", "950\n")
+	time.Sleep(time.Millisecond * 10)
+	if len(c) != 0 {
+		t.Error("Shutdown started after Stop.")
+	}
+
+	cm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+	defer cm.Stop()
+	waitShutdown(t, c)
+}
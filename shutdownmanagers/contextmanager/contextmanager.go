@@ -0,0 +1,85 @@
+/*
+ContextManager starts shutdown when a context is done. It is useful when
+gracefulshutdown is embedded in a framework that already cancels a root
+context on shutdown.
+
+For the opposite direction, use GracefulShutdown.Context, which is
+cancelled when shutdown starts. Do not pass that context to
+ContextManager.
+*/
+package contextmanager
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+const Name = "ContextManager"
+
+// ContextManager implements ShutdownManager interface that is added
+// to GracefulShutdown. Initialize with NewContextManager.
+type ContextManager struct {
+	ctx context.Context
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewContextManager initializes the ContextManager with the context
+// to watch.
+func NewContextManager(ctx context.Context) *ContextManager {
+	return &ContextManager{
+		ctx: ctx,
+	}
+}
+
+// GetName returns name of this ShutdownManager.
+func (contextManager *ContextManager) GetName() string {
+	return Name
+}
+
+// Start starts waiting for the context to be done.
+func (contextManager *ContextManager) Start(gs gracefulshutdown.GSInterface) error {
+	stop := make(chan struct{})
+	contextManager.stop = stop
+	contextManager.stopOnce = sync.Once{}
+	go func() {
+		select {
+		case <-contextManager.ctx.Done():
+			gs.StartShutdown(contextManager)
+		case <-stop:
+		}
+	}()
+
+	return nil
+}
+
+// Reason returns the cause of the context cancellation.
+func (contextManager *ContextManager) Reason() string {
+	if err := context.Cause(contextManager.ctx); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// Stop stops waiting for the context to be done.
+func (contextManager *ContextManager) Stop() error {
+	contextManager.stopOnce.Do(func() {
+		if contextManager.stop != nil {
+			close(contextManager.stop)
+		}
+	})
+	return nil
+}
+
+// ShutdownStart does nothing.
+func (contextManager *ContextManager) ShutdownStart() error {
+	return nil
+}
+
+// ShutdownFinish does nothing.
+func (contextManager *ContextManager) ShutdownFinish() error {
+	return nil
+}
@@ -0,0 +1,88 @@
+package contextmanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/internal/testutil"
+)
+
+type GSFunc func(sm gracefulshutdown.ShutdownManager)
+
+func (f GSFunc) StartShutdown(sm gracefulshutdown.ShutdownManager) {
+	f(sm)
+}
+
+func (f GSFunc) ReportError(err error) {
+
+}
+
+func (f GSFunc) AddShutdownCallback(shutdownCallback gracefulshutdown.ShutdownCallback) {
+
+}
+
+func TestShutdownOnCancel(t *testing.T) {
+	c := make(chan int, 100)
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	cm := NewContextManager(ctx)
+	cm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	time.Sleep(time.Millisecond * 5)
+	if len(c) != 0 {
+		t.Fatal("Shutdown started before cancel.")
+	}
+
+	cancel(errors.New("framework stopping"))
+
+	select {
+	case <-c:
+
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for StartShutdown.")
+	}
+
+	if cm.Reason() != "framework stopping" {
+		t.Error("Expected reason 'framework stopping', got", cm.Reason())
+	}
+}
+
+func TestStop(t *testing.T) {
+	c := make(chan int, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	goroutines := testutil.Goroutines()
+	cm := NewContextManager(ctx)
+	cm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	if err := cm.Stop(); err != nil {
+		t.Error("Error in stop:", err)
+	}
+	cm.Stop()
+	testutil.WaitGoroutines(t, goroutines)
+
+	cancel()
+	time.Sleep(time.Millisecond * 5)
+	if len(c) != 0 {
+		t.Error("Shutdown started after Stop.")
+	}
+
+	cm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+	defer cm.Stop()
+
+	select {
+	case <-c:
+	case <-time.After(time.Second):
+		t.Error("Expected shutdown after restart.")
+	}
+}
@@ -0,0 +1,176 @@
+/*
+FileManager provides a listener for a sentinel file. It polls the
+configured path and starts shutdown when the file appears or its
+modification time changes. Contents of the file are used as reason
+for shutdown, available with Reason.
+
+A file that already exists when FileManager is started does not trigger
+shutdown until it is touched, so stale sentinel files from previous runs
+are ignored.
+
+FileManager does not exit the process. Use GracefulShutdown.SetExitPolicy
+to exit when shutdown finishes.
+*/
+package filemanager
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+const (
+	Name = "FileManager"
+
+	defaultPollInterval = time.Second
+)
+
+// ErrNoPath is returned from Start if Path is not set.
+var ErrNoPath = errors.New("filemanager: Path is required")
+
+// FileManager implements ShutdownManager interface that is added
+// to GracefulShutdown. Initialize with NewFileManager.
+type FileManager struct {
+	gs     gracefulshutdown.GSInterface
+	config *FileManagerConfig
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mutex  sync.Mutex
+	reason string
+}
+
+// FileManagerConfig provides configuration options for FileManager.
+type FileManagerConfig struct {
+	// Path of the sentinel file.
+	Path string
+
+	// PollInterval is period for checking the sentinel file.
+	// Default is 1 second.
+	PollInterval time.Duration
+}
+
+func (fmc *FileManagerConfig) clean() {
+	if fmc.PollInterval == 0 {
+		fmc.PollInterval = defaultPollInterval
+	}
+}
+
+// NewFileManager initializes the FileManager. See FileManagerConfig for
+// configuration options.
+func NewFileManager(fileManagerConfig *FileManagerConfig) *FileManager {
+	if fileManagerConfig == nil {
+		fileManagerConfig = &FileManagerConfig{}
+	}
+	fileManagerConfig.clean()
+	return &FileManager{
+		config: fileManagerConfig,
+	}
+}
+
+// GetName returns name of this ShutdownManager.
+func (fileManager *FileManager) GetName() string {
+	return Name
+}
+
+// Start starts polling the sentinel file. Will return error if Path is
+// not set or if the file can not be checked.
+func (fileManager *FileManager) Start(gs gracefulshutdown.GSInterface) error {
+	fileManager.gs = gs
+
+	if fileManager.config.Path == "" {
+		return ErrNoPath
+	}
+
+	modTime, err := fileManager.modTime()
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	fileManager.stop = stop
+	fileManager.stopOnce = sync.Once{}
+	go fileManager.poll(stop, modTime)
+
+	return nil
+}
+
+// Reason returns contents of the sentinel file that triggered shutdown.
+func (fileManager *FileManager) Reason() string {
+	fileManager.mutex.Lock()
+	defer fileManager.mutex.Unlock()
+	return fileManager.reason
+}
+
+// modTime returns modification time of the sentinel file, or zero time
+// if it does not exist.
+func (fileManager *FileManager) modTime() (time.Time, error) {
+	info, err := os.Stat(fileManager.config.Path)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func (fileManager *FileManager) poll(stop <-chan struct{}, lastModTime time.Time) {
+	ticker := time.NewTicker(fileManager.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		modTime, err := fileManager.modTime()
+		if err != nil {
+			fileManager.gs.ReportError(gracefulshutdown.NewError(Name, "poll", gracefulshutdown.SeverityWarning, err))
+			continue
+		}
+
+		if modTime.IsZero() || modTime.Equal(lastModTime) {
+			lastModTime = modTime
+			continue
+		}
+
+		contents, err := ioutil.ReadFile(fileManager.config.Path)
+		fileManager.gs.ReportError(gracefulshutdown.NewError(Name, "read", gracefulshutdown.SeverityWarning, err))
+
+		fileManager.mutex.Lock()
+		fileManager.reason = strings.TrimSpace(string(contents))
+		fileManager.mutex.Unlock()
+
+		fileManager.gs.StartShutdown(fileManager)
+		return
+	}
+}
+
+// Stop stops polling the sentinel file.
+func (fileManager *FileManager) Stop() error {
+	fileManager.stopOnce.Do(func() {
+		if fileManager.stop != nil {
+			close(fileManager.stop)
+		}
+	})
+	return nil
+}
+
+// ShutdownStart does nothing.
+func (fileManager *FileManager) ShutdownStart() error {
+	return nil
+}
+
+// ShutdownFinish does nothing.
+func (fileManager *FileManager) ShutdownFinish() error {
+	return nil
+}
@@ -0,0 +1,152 @@
+package filemanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/internal/testutil"
+)
+
+type GSFunc func(sm gracefulshutdown.ShutdownManager)
+
+func (f GSFunc) StartShutdown(sm gracefulshutdown.ShutdownManager) {
+	f(sm)
+}
+
+func (f GSFunc) ReportError(err error) {
+
+}
+
+func (f GSFunc) AddShutdownCallback(shutdownCallback gracefulshutdown.ShutdownCallback) {
+
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "filemanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func waitShutdown(t *testing.T, c <-chan int) {
+	select {
+	case <-c:
+
+	case <-time.After(1 * time.Second):
+		t.Error("Timeout waiting for StartShutdown.")
+	}
+}
+
+func TestStartRequiresPath(t *testing.T) {
+	fm := NewFileManager(nil)
+
+	if err := fm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {})); err != ErrNoPath {
+		t.Error("Expected ErrNoPath, got", err)
+	}
+}
+
+func TestFileAppears(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	c := make(chan int, 100)
+	path := filepath.Join(dir, "shutdown")
+	fm := NewFileManager(&FileManagerConfig{
+		Path:         path,
+		PollInterval: time.Millisecond * 5,
+	})
+	fm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	time.Sleep(time.Millisecond * 10)
+	if len(c) != 0 {
+		t.Error("Shutdown started without file.")
+	}
+
+	ioutil.WriteFile(path, []byte("deploy v42\n"), 0644)
+
+	waitShutdown(t, c)
+
+	if fm.Reason() != "deploy v42" {
+		t.Error("Expected reason 'deploy v42', got", fm.Reason())
+	}
+}
+
+func TestExistingFileTouched(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	c := make(chan int, 100)
+	path := filepath.Join(dir, "shutdown")
+	ioutil.WriteFile(path, []byte("stale"), 0644)
+
+	fm := NewFileManager(&FileManagerConfig{
+		Path:         path,
+		PollInterval: time.Millisecond * 5,
+	})
+	fm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	time.Sleep(time.Millisecond * 20)
+	if len(c) != 0 {
+		t.Error("Shutdown started on stale file.")
+	}
+
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+
+	waitShutdown(t, c)
+
+	if fm.Reason() != "stale" {
+		t.Error("Expected reason 'stale', got", fm.Reason())
+	}
+}
+
+func TestStop(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	goroutines := testutil.Goroutines()
+
+	c := make(chan int, 100)
+	path := filepath.Join(dir, "shutdown")
+	fm := NewFileManager(&FileManagerConfig{
+		Path:         path,
+		PollInterval: time.Millisecond * 5,
+	})
+	fm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	fm.Stop()
+	fm.Stop()
+	testutil.WaitGoroutines(t, goroutines)
+	ioutil.WriteFile(path, []byte("deploy v42\n"), 0644)
+
+	time.Sleep(time.Millisecond * 20)
+	if len(c) != 0 {
+		t.Error("Shutdown started after Stop.")
+	}
+
+	// file written while stopped does not start shutdown after restart
+	fm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+	defer fm.Stop()
+
+	time.Sleep(time.Millisecond * 20)
+	if len(c) != 0 {
+		t.Error("Shutdown started for file written before restart.")
+	}
+
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	waitShutdown(t, c)
+}
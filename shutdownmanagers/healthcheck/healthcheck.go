@@ -0,0 +1,212 @@
+/*
+HealthCheckManager runs registered health checks every Interval and
+starts shutdown when any check fails Failures times in a row, so an
+orchestrator replaces the unhealthy instance. Every failed check is
+reported as an error.
+
+HealthCheckManager does not exit the process. Use
+GracefulShutdown.SetExitPolicy to exit when shutdown finishes.
+*/
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+const (
+	Name = "HealthCheckManager"
+
+	defaultInterval = time.Second * 10
+	defaultFailures = 3
+)
+
+// Check is an interface you have to implement for health checks.
+// Check should return an error if the application is unhealthy.
+// The context is cancelled after Timeout.
+type Check interface {
+	Check(ctx context.Context) error
+}
+
+// CheckFunc is a helper type, so you can easily provide anonymous functions
+// as Checks.
+type CheckFunc func(ctx context.Context) error
+
+func (f CheckFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// HealthCheckManager implements ShutdownManager interface that is added
+// to GracefulShutdown. Initialize with NewHealthCheckManager.
+type HealthCheckManager struct {
+	gs     gracefulshutdown.GSInterface
+	config *HealthCheckManagerConfig
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mutex  sync.Mutex
+	checks []*namedCheck
+	reason string
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// HealthCheckManagerConfig provides configuration options for HealthCheckManager.
+type HealthCheckManagerConfig struct {
+	// Interval is period for running health checks. Default is 10 seconds.
+	Interval time.Duration
+
+	// Timeout is time a single check can take. Default is Interval.
+	Timeout time.Duration
+
+	// Failures is number of consecutive failures of a check that
+	// starts shutdown. Default is 3.
+	Failures int
+}
+
+func (hcmc *HealthCheckManagerConfig) clean() {
+	if hcmc.Interval == 0 {
+		hcmc.Interval = defaultInterval
+	}
+	if hcmc.Timeout == 0 {
+		hcmc.Timeout = hcmc.Interval
+	}
+	if hcmc.Failures <= 0 {
+		hcmc.Failures = defaultFailures
+	}
+}
+
+// NewHealthCheckManager initializes the HealthCheckManager. See
+// HealthCheckManagerConfig for configuration options.
+func NewHealthCheckManager(healthCheckManagerConfig *HealthCheckManagerConfig) *HealthCheckManager {
+	if healthCheckManagerConfig == nil {
+		healthCheckManagerConfig = &HealthCheckManagerConfig{}
+	}
+	healthCheckManagerConfig.clean()
+	return &HealthCheckManager{
+		config: healthCheckManagerConfig,
+	}
+}
+
+// GetName returns name of this ShutdownManager.
+func (healthCheckManager *HealthCheckManager) GetName() string {
+	return Name
+}
+
+// AddCheck adds a Check with a name used in errors and reason.
+//
+// You can provide anything that implements Check interface,
+// or you can supply a function like this:
+//
+//	AddCheck("db", healthcheck.CheckFunc(func(ctx context.Context) error {
+//		return db.PingContext(ctx)
+//	}))
+func (healthCheckManager *HealthCheckManager) AddCheck(name string, check Check) {
+	healthCheckManager.mutex.Lock()
+	defer healthCheckManager.mutex.Unlock()
+	healthCheckManager.checks = append(healthCheckManager.checks, &namedCheck{
+		name:  name,
+		check: check,
+	})
+}
+
+// Start starts running health checks.
+func (healthCheckManager *HealthCheckManager) Start(gs gracefulshutdown.GSInterface) error {
+	healthCheckManager.gs = gs
+
+	stop := make(chan struct{})
+	healthCheckManager.stop = stop
+	healthCheckManager.stopOnce = sync.Once{}
+	go healthCheckManager.run(stop)
+
+	return nil
+}
+
+// Reason returns the check that started shutdown and its last error.
+func (healthCheckManager *HealthCheckManager) Reason() string {
+	healthCheckManager.mutex.Lock()
+	defer healthCheckManager.mutex.Unlock()
+	return healthCheckManager.reason
+}
+
+func (healthCheckManager *HealthCheckManager) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(healthCheckManager.config.Interval)
+	defer ticker.Stop()
+
+	// failures are counted per run, so a run left over from before
+	// Stop does not share them with the next Start
+	failures := make(map[*namedCheck]int)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if healthCheckManager.runChecks(failures) {
+			healthCheckManager.gs.StartShutdown(healthCheckManager)
+			return
+		}
+	}
+}
+
+// runChecks runs all checks, counting consecutive failures of each in
+// failures, and returns true if shutdown should start.
+func (healthCheckManager *HealthCheckManager) runChecks(failures map[*namedCheck]int) bool {
+	healthCheckManager.mutex.Lock()
+	checks := healthCheckManager.checks
+	healthCheckManager.mutex.Unlock()
+
+	for _, check := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), healthCheckManager.config.Timeout)
+		err := check.check.Check(ctx)
+		cancel()
+
+		if err == nil {
+			delete(failures, check)
+			continue
+		}
+
+		failures[check]++
+		// keep the message stable so repeated failures can be deduplicated
+		err = fmt.Errorf("health check %s failed: %v", check.name, err)
+		healthCheckManager.gs.ReportError(gracefulshutdown.NewError(Name, "check "+check.name, gracefulshutdown.SeverityWarning, err))
+
+		if failures[check] >= healthCheckManager.config.Failures {
+			healthCheckManager.mutex.Lock()
+			healthCheckManager.reason = fmt.Sprintf("%v (%d times in a row)", err, failures[check])
+			healthCheckManager.mutex.Unlock()
+			return true
+		}
+	}
+	return false
+}
+
+// Stop stops running health checks.
+func (healthCheckManager *HealthCheckManager) Stop() error {
+	healthCheckManager.stopOnce.Do(func() {
+		if healthCheckManager.stop != nil {
+			close(healthCheckManager.stop)
+		}
+	})
+	return nil
+}
+
+// ShutdownStart does nothing.
+func (healthCheckManager *HealthCheckManager) ShutdownStart() error {
+	return nil
+}
+
+// ShutdownFinish does nothing.
+func (healthCheckManager *HealthCheckManager) ShutdownFinish() error {
+	return nil
+}
@@ -0,0 +1,160 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/internal/testutil"
+)
+
+type gsMock struct {
+	shutdowns chan int
+	errors    chan error
+}
+
+func newGsMock() *gsMock {
+	return &gsMock{
+		shutdowns: make(chan int, 100),
+		errors:    make(chan error, 100),
+	}
+}
+
+func (gs *gsMock) StartShutdown(sm gracefulshutdown.ShutdownManager) {
+	gs.shutdowns <- 1
+}
+
+func (gs *gsMock) ReportError(err error) {
+	if err != nil {
+		gs.errors <- err
+	}
+}
+
+func (gs *gsMock) AddShutdownCallback(shutdownCallback gracefulshutdown.ShutdownCallback) {
+
+}
+
+func TestConsecutiveFailures(t *testing.T) {
+	gs := newGsMock()
+	hcm := NewHealthCheckManager(&HealthCheckManagerConfig{
+		Failures: 3,
+	})
+
+	results := []error{errors.New("down"), errors.New("down"), nil, errors.New("down"), errors.New("down"), errors.New("down")}
+	hcm.AddCheck("ok", CheckFunc(func(ctx context.Context) error {
+		return nil
+	}))
+	hcm.AddCheck("db", CheckFunc(func(ctx context.Context) error {
+		err := results[0]
+		results = results[1:]
+		return err
+	}))
+	hcm.gs = gs
+
+	failures := make(map[*namedCheck]int)
+	for i := 0; i < 5; i++ {
+		if hcm.runChecks(failures) {
+			t.Fatal("Shutdown requested after", i+1, "runs.")
+		}
+	}
+
+	if !hcm.runChecks(failures) {
+		t.Fatal("Shutdown not requested after 3 consecutive failures.")
+	}
+
+	if len(gs.errors) != 5 {
+		t.Error("Expected 5 reported errors, got", len(gs.errors))
+	}
+
+	first := <-gs.errors
+	for i := 1; i < 5; i++ {
+		if err := <-gs.errors; err.Error() != first.Error() {
+			t.Error("Expected identical error messages, got", err)
+		}
+	}
+
+	if !strings.Contains(hcm.Reason(), "health check db failed: down (3 times in a row)") {
+		t.Error("Unexpected reason:", hcm.Reason())
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	gs := newGsMock()
+	hcm := NewHealthCheckManager(&HealthCheckManagerConfig{
+		Timeout:  time.Millisecond,
+		Failures: 1,
+	})
+	hcm.AddCheck("slow", CheckFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	hcm.gs = gs
+
+	if !hcm.runChecks(make(map[*namedCheck]int)) {
+		t.Error("Shutdown not requested after timeout.")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	gs := newGsMock()
+	hcm := NewHealthCheckManager(&HealthCheckManagerConfig{
+		Interval: time.Millisecond,
+		Failures: 2,
+	})
+	hcm.AddCheck("disk", CheckFunc(func(ctx context.Context) error {
+		return errors.New("read-only")
+	}))
+	hcm.Start(gs)
+
+	select {
+	case <-gs.shutdowns:
+
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for StartShutdown.")
+	}
+}
+
+func TestStop(t *testing.T) {
+	gs := newGsMock()
+	hcm := NewHealthCheckManager(&HealthCheckManagerConfig{
+		Interval: time.Millisecond,
+		Failures: 1,
+	})
+
+	var down atomic.Bool
+	hcm.AddCheck("disk", CheckFunc(func(ctx context.Context) error {
+		if down.Load() {
+			return errors.New("read-only")
+		}
+		return nil
+	}))
+
+	goroutines := testutil.Goroutines()
+	hcm.Start(gs)
+	time.Sleep(time.Millisecond * 5)
+
+	if err := hcm.Stop(); err != nil {
+		t.Error("Error in stop:", err)
+	}
+	hcm.Stop()
+	testutil.WaitGoroutines(t, goroutines)
+
+	down.Store(true)
+	time.Sleep(time.Millisecond * 10)
+	if len(gs.shutdowns) != 0 || len(gs.errors) != 0 {
+		t.Error("Checks ran after Stop.")
+	}
+
+	hcm.Start(gs)
+	defer hcm.Stop()
+
+	select {
+	case <-gs.shutdowns:
+	case <-time.After(time.Second):
+		t.Error("Expected shutdown after restart.")
+	}
+}
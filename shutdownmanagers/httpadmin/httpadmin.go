@@ -0,0 +1,432 @@
+/*
+HttpAdminManager provides an authenticated http endpoint for requesting
+shutdown. It serves:
+
+	POST /shutdown  starts shutdown, optionally after Delay
+	POST /abort     aborts requested shutdown while it is still delayed
+	GET  /status    returns shutdown progress as json
+
+Shutdown request body can be a json object with reason for shutdown:
+
+	{"reason": "deploy"}
+
+Every request has to be authenticated with a bearer token:
+
+	Authorization: Bearer <Token>
+
+or signed with HMAC-SHA256 of method, path, timestamp and body:
+
+	X-Timestamp: <unix seconds>
+	X-Signature: sha256=<hex(hmac(HmacKey, method + "\n" + path + "\n" + timestamp + "\n" + body))>
+
+so a signature of one request can not be replayed on another endpoint.
+A signed POST request is accepted only once, so it can not be replayed
+while its timestamp is within MaxClockSkew, like to request shutdown
+again after it was aborted.
+Request bodies are limited to 64 KiB.
+
+It listens on a tcp address or, if Network is "unix", on a unix socket.
+When shutdown started by HttpAdminManager finishes, the http server is
+shut down gracefully.
+*/
+package httpadmin
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+const (
+	Name = "HttpAdminManager"
+
+	defaultNetwork      = "tcp"
+	defaultMaxClockSkew = time.Minute * 5
+	shutdownTimeout     = time.Second * 5
+	maxBodySize         = 64 << 10
+
+	StateRunning      = "running"
+	StatePending      = "pending"
+	StateShuttingDown = "shutting_down"
+	StateFinished     = "finished"
+)
+
+// ErrNoAuth is returned from Start if neither Token nor HmacKey is set.
+var ErrNoAuth = errors.New("httpadmin: Token or HmacKey is required")
+
+// HttpAdminManager implements ShutdownManager interface that is added
+// to GracefulShutdown. Initialize with NewHttpAdminManager.
+type HttpAdminManager struct {
+	gs     gracefulshutdown.GSInterface
+	config *HttpAdminManagerConfig
+	mux    *http.ServeMux
+	server *http.Server
+
+	mutex    sync.Mutex
+	timer    *time.Timer
+	status   Status
+	serveErr error
+
+	// used holds signatures of accepted POST requests until their
+	// timestamp is outside MaxClockSkew
+	used map[string]time.Time
+}
+
+// HttpAdminManagerConfig provides configuration options for HttpAdminManager.
+type HttpAdminManagerConfig struct {
+	// Network is "tcp" or "unix". Default is "tcp".
+	Network string
+
+	// Address is host:port for tcp or path of the socket for unix.
+	// If empty, http is disabled and ServeHTTP can be used with
+	// an existing http server.
+	Address string
+
+	// Token is the bearer token that authenticates requests.
+	Token string
+
+	// HmacKey is the key for verifying request signatures.
+	HmacKey []byte
+
+	// MaxClockSkew is maximum difference between X-Timestamp and
+	// current time for signed requests. Default is 5 minutes.
+	MaxClockSkew time.Duration
+
+	// Delay is time between shutdown request and start of shutdown,
+	// during which shutdown can be aborted. Default is 0.
+	Delay time.Duration
+}
+
+// Status is returned from /status endpoint.
+type Status struct {
+	State       string    `json:"state"`
+	Reason      string    `json:"reason,omitempty"`
+	Manager     string    `json:"manager,omitempty"`
+	RequestedAt time.Time `json:"requestedAt,omitzero"`
+	StartedAt   time.Time `json:"startedAt,omitzero"`
+	FinishedAt  time.Time `json:"finishedAt,omitzero"`
+}
+
+type shutdownRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (hamc *HttpAdminManagerConfig) clean() {
+	if hamc.Network == "" {
+		hamc.Network = defaultNetwork
+	}
+	if hamc.MaxClockSkew == 0 {
+		hamc.MaxClockSkew = defaultMaxClockSkew
+	}
+}
+
+// NewHttpAdminManager initializes the HttpAdminManager. See
+// HttpAdminManagerConfig for configuration options.
+func NewHttpAdminManager(httpAdminManagerConfig *HttpAdminManagerConfig) *HttpAdminManager {
+	if httpAdminManagerConfig == nil {
+		httpAdminManagerConfig = &HttpAdminManagerConfig{}
+	}
+	httpAdminManagerConfig.clean()
+
+	httpAdminManager := &HttpAdminManager{
+		config: httpAdminManagerConfig,
+		mux:    http.NewServeMux(),
+		status: Status{State: StateRunning},
+		used:   make(map[string]time.Time),
+	}
+	httpAdminManager.mux.HandleFunc("/shutdown", httpAdminManager.serveShutdown)
+	httpAdminManager.mux.HandleFunc("/abort", httpAdminManager.serveAbort)
+	httpAdminManager.mux.HandleFunc("/status", httpAdminManager.serveStatus)
+	return httpAdminManager
+}
+
+// GetName returns name of this ShutdownManager.
+func (httpAdminManager *HttpAdminManager) GetName() string {
+	return Name
+}
+
+// Start starts listening for requests. Will return error if no
+// authentication is configured or if listening on Address fails.
+func (httpAdminManager *HttpAdminManager) Start(gs gracefulshutdown.GSInterface) error {
+	httpAdminManager.gs = gs
+
+	if httpAdminManager.config.Token == "" && len(httpAdminManager.config.HmacKey) == 0 {
+		return ErrNoAuth
+	}
+
+	httpAdminManager.gs.AddShutdownCallback(httpAdminManager)
+
+	if httpAdminManager.config.Address == "" {
+		return nil
+	}
+
+	listener, err := net.Listen(httpAdminManager.config.Network, httpAdminManager.config.Address)
+	if err != nil {
+		return err
+	}
+
+	httpAdminManager.server = &http.Server{Handler: httpAdminManager}
+	go func() {
+		if err := httpAdminManager.server.Serve(listener); err != http.ErrServerClosed {
+			httpAdminManager.mutex.Lock()
+			httpAdminManager.serveErr = err
+			httpAdminManager.mutex.Unlock()
+
+			httpAdminManager.gs.ReportError(gracefulshutdown.NewError(Name, "serve", gracefulshutdown.SeverityError, err))
+		}
+	}()
+
+	return nil
+}
+
+// Reason returns reason given in the shutdown request.
+func (httpAdminManager *HttpAdminManager) Reason() string {
+	return httpAdminManager.Status().Reason
+}
+
+// Status returns current shutdown progress.
+func (httpAdminManager *HttpAdminManager) Status() Status {
+	httpAdminManager.mutex.Lock()
+	defer httpAdminManager.mutex.Unlock()
+	return httpAdminManager.status
+}
+
+// OnShutdown records start of shutdown in status. If shutdown was
+// requested by another ShutdownManager, pending shutdown is cancelled.
+func (httpAdminManager *HttpAdminManager) OnShutdown(shutdownManager string) error {
+	httpAdminManager.mutex.Lock()
+	defer httpAdminManager.mutex.Unlock()
+
+	if httpAdminManager.status.State == StatePending && shutdownManager != Name {
+		httpAdminManager.timer.Stop()
+	}
+
+	httpAdminManager.status.State = StateShuttingDown
+	httpAdminManager.status.Manager = shutdownManager
+	httpAdminManager.status.StartedAt = time.Now()
+	return nil
+}
+
+// Stop cancels pending shutdown and closes the listener.
+func (httpAdminManager *HttpAdminManager) Stop() error {
+	httpAdminManager.mutex.Lock()
+	if httpAdminManager.status.State == StatePending {
+		httpAdminManager.timer.Stop()
+		httpAdminManager.status = Status{State: StateRunning}
+	}
+	httpAdminManager.mutex.Unlock()
+
+	if httpAdminManager.server == nil {
+		return nil
+	}
+	return httpAdminManager.server.Close()
+}
+
+// Health returns an error if http server failed, so shutdown requests
+// are not received.
+func (httpAdminManager *HttpAdminManager) Health() error {
+	httpAdminManager.mutex.Lock()
+	defer httpAdminManager.mutex.Unlock()
+
+	if httpAdminManager.serveErr != nil {
+		return fmt.Errorf("http server failed: %w", httpAdminManager.serveErr)
+	}
+	return nil
+}
+
+// ServeHTTP serves /shutdown, /abort and /status endpoints.
+func (httpAdminManager *HttpAdminManager) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	httpAdminManager.mux.ServeHTTP(w, req)
+}
+
+func (httpAdminManager *HttpAdminManager) serveShutdown(w http.ResponseWriter, req *http.Request) {
+	body, ok := httpAdminManager.authenticate(w, req, "POST")
+	if !ok {
+		return
+	}
+
+	request := &shutdownRequest{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	httpAdminManager.mutex.Lock()
+	defer httpAdminManager.mutex.Unlock()
+
+	if httpAdminManager.status.State != StateRunning {
+		http.Error(w, "shutdown already requested", http.StatusConflict)
+		return
+	}
+
+	httpAdminManager.status.State = StatePending
+	httpAdminManager.status.Reason = request.Reason
+	httpAdminManager.status.RequestedAt = time.Now()
+	httpAdminManager.timer = time.AfterFunc(httpAdminManager.config.Delay, func() {
+		httpAdminManager.gs.StartShutdown(httpAdminManager)
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (httpAdminManager *HttpAdminManager) serveAbort(w http.ResponseWriter, req *http.Request) {
+	if _, ok := httpAdminManager.authenticate(w, req, "POST"); !ok {
+		return
+	}
+
+	httpAdminManager.mutex.Lock()
+	defer httpAdminManager.mutex.Unlock()
+
+	if httpAdminManager.status.State != StatePending || !httpAdminManager.timer.Stop() {
+		http.Error(w, "no pending shutdown", http.StatusConflict)
+		return
+	}
+
+	httpAdminManager.status = Status{State: StateRunning}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (httpAdminManager *HttpAdminManager) serveStatus(w http.ResponseWriter, req *http.Request) {
+	if _, ok := httpAdminManager.authenticate(w, req, "GET"); !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(httpAdminManager.Status())
+}
+
+// authenticate checks request method and authentication and returns
+// request body. If it returns false, response has already been written.
+func (httpAdminManager *HttpAdminManager) authenticate(w http.ResponseWriter, req *http.Request, method string) ([]byte, bool) {
+	if req.Method != method {
+		w.Header().Set("Allow", method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxBodySize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+
+	if !httpAdminManager.checkToken(req) && !httpAdminManager.checkSignature(req, body) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return body, true
+}
+
+func (httpAdminManager *HttpAdminManager) checkToken(req *http.Request) bool {
+	if httpAdminManager.config.Token == "" {
+		return false
+	}
+
+	authorization := req.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return false
+	}
+
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(httpAdminManager.config.Token)) == 1
+}
+
+func (httpAdminManager *HttpAdminManager) checkSignature(req *http.Request, body []byte) bool {
+	if len(httpAdminManager.config.HmacKey) == 0 {
+		return false
+	}
+
+	timestamp := req.Header.Get("X-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	skew := time.Since(time.Unix(seconds, 0))
+	if skew > httpAdminManager.config.MaxClockSkew || -skew > httpAdminManager.config.MaxClockSkew {
+		return false
+	}
+
+	signature, err := hex.DecodeString(strings.TrimPrefix(req.Header.Get("X-Signature"), "sha256="))
+	if err != nil {
+		return false
+	}
+
+	if !hmac.Equal(signature, Sign(httpAdminManager.config.HmacKey, req.Method, req.URL.Path, timestamp, body)) {
+		return false
+	}
+
+	// GET requests do not change state and can be repeated
+	if req.Method == "GET" {
+		return true
+	}
+	return httpAdminManager.useSignature(string(signature), time.Unix(seconds, 0).Add(httpAdminManager.config.MaxClockSkew))
+}
+
+// useSignature records signature until expires and returns false if
+// it was already used.
+func (httpAdminManager *HttpAdminManager) useSignature(signature string, expires time.Time) bool {
+	httpAdminManager.mutex.Lock()
+	defer httpAdminManager.mutex.Unlock()
+
+	now := time.Now()
+	for used, usedExpires := range httpAdminManager.used {
+		if now.After(usedExpires) {
+			delete(httpAdminManager.used, used)
+		}
+	}
+
+	if _, ok := httpAdminManager.used[signature]; ok {
+		return false
+	}
+	httpAdminManager.used[signature] = expires
+	return true
+}
+
+// Sign returns HMAC-SHA256 signature of method, path, timestamp and body,
+// as expected in X-Signature header.
+func Sign(key []byte, method, path, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(method + "\n" + path + "\n" + timestamp + "\n"))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// ShutdownStart does nothing.
+func (httpAdminManager *HttpAdminManager) ShutdownStart() error {
+	return nil
+}
+
+// ShutdownFinish records end of shutdown and gracefully shuts down
+// the http server.
+func (httpAdminManager *HttpAdminManager) ShutdownFinish() error {
+	httpAdminManager.mutex.Lock()
+	httpAdminManager.status.State = StateFinished
+	httpAdminManager.status.FinishedAt = time.Now()
+	httpAdminManager.mutex.Unlock()
+
+	if httpAdminManager.server == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return httpAdminManager.server.Shutdown(ctx)
+}
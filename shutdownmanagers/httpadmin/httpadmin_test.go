@@ -0,0 +1,415 @@
+package httpadmin
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/internal/testutil"
+)
+
+type GSFunc func(sm gracefulshutdown.ShutdownManager)
+
+func (f GSFunc) StartShutdown(sm gracefulshutdown.ShutdownManager) {
+	f(sm)
+}
+
+func (f GSFunc) ReportError(err error) {
+
+}
+
+func (f GSFunc) AddShutdownCallback(shutdownCallback gracefulshutdown.ShutdownCallback) {
+
+}
+
+func request(ham *HttpAdminManager, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	ham.ServeHTTP(w, req)
+	return w
+}
+
+var bearer = map[string]string{"Authorization": "Bearer my-token"}
+
+func TestStartRequiresAuth(t *testing.T) {
+	ham := NewHttpAdminManager(nil)
+
+	if err := ham.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {})); err != ErrNoAuth {
+		t.Error("Expected ErrNoAuth, got", err)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	c := make(chan int, 100)
+	ham := NewHttpAdminManager(&HttpAdminManagerConfig{Token: "my-token"})
+	ham.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	w := request(ham, "POST", "/shutdown", "", map[string]string{"Authorization": "Bearer other-token"})
+	if w.Code != http.StatusUnauthorized {
+		t.Error("Expected 401, got", w.Code)
+	}
+
+	w = request(ham, "GET", "/status", "", nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Error("Expected 401, got", w.Code)
+	}
+
+	time.Sleep(time.Millisecond * 5)
+	if len(c) != 0 {
+		t.Error("Shutdown started.")
+	}
+}
+
+func TestShutdownWithToken(t *testing.T) {
+	c := make(chan int, 100)
+	ham := NewHttpAdminManager(&HttpAdminManagerConfig{Token: "my-token"})
+	ham.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	w := request(ham, "GET", "/shutdown", "", bearer)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Error("Expected 405, got", w.Code)
+	}
+
+	w = request(ham, "POST", "/shutdown", `{"reason":"deploy"}`, bearer)
+	if w.Code != http.StatusAccepted {
+		t.Error("Expected 202, got", w.Code)
+	}
+
+	time.Sleep(time.Millisecond * 5)
+	if len(c) != 1 {
+		t.Error("Shutdown not started.")
+	}
+
+	if ham.Reason() != "deploy" {
+		t.Error("Expected reason deploy, got", ham.Reason())
+	}
+
+	w = request(ham, "POST", "/shutdown", "", bearer)
+	if w.Code != http.StatusConflict {
+		t.Error("Expected 409 on repeated shutdown, got", w.Code)
+	}
+}
+
+func TestShutdownWithSignature(t *testing.T) {
+	c := make(chan int, 100)
+	key := []byte("my-key")
+	ham := NewHttpAdminManager(&HttpAdminManagerConfig{HmacKey: key})
+	ham.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	body := `{"reason":"signed"}`
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	w := request(ham, "POST", "/shutdown", body, map[string]string{
+		"X-Timestamp": old,
+		"X-Signature": "sha256=" + hex.EncodeToString(Sign(key, "POST", "/shutdown", old, []byte(body))),
+	})
+	if w.Code != http.StatusUnauthorized {
+		t.Error("Expected 401 for old timestamp, got", w.Code)
+	}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	w = request(ham, "POST", "/shutdown", body, map[string]string{
+		"X-Timestamp": now,
+		"X-Signature": "sha256=" + hex.EncodeToString(Sign([]byte("other-key"), "POST", "/shutdown", now, []byte(body))),
+	})
+	if w.Code != http.StatusUnauthorized {
+		t.Error("Expected 401 for wrong key, got", w.Code)
+	}
+
+	w = request(ham, "POST", "/shutdown", body, map[string]string{
+		"X-Timestamp": now,
+		"X-Signature": "sha256=" + hex.EncodeToString(Sign(key, "POST", "/shutdown", now, []byte(body))),
+	})
+	if w.Code != http.StatusAccepted {
+		t.Error("Expected 202, got", w.Code)
+	}
+
+	time.Sleep(time.Millisecond * 5)
+	if len(c) != 1 {
+		t.Error("Shutdown not started.")
+	}
+}
+
+func TestSignatureNotReplayedOnOtherEndpoint(t *testing.T) {
+	c := make(chan int, 100)
+	key := []byte("my-key")
+	ham := NewHttpAdminManager(&HttpAdminManagerConfig{HmacKey: key})
+	ham.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	headers := map[string]string{
+		"X-Timestamp": now,
+		"X-Signature": "sha256=" + hex.EncodeToString(Sign(key, "GET", "/status", now, nil)),
+	}
+
+	if w := request(ham, "GET", "/status", "", headers); w.Code != http.StatusOK {
+		t.Error("Expected 200 for status, got", w.Code)
+	}
+
+	if w := request(ham, "POST", "/shutdown", "", headers); w.Code != http.StatusUnauthorized {
+		t.Error("Expected 401 for status signature on shutdown, got", w.Code)
+	}
+
+	time.Sleep(time.Millisecond * 5)
+	if len(c) != 0 {
+		t.Error("Shutdown started with replayed signature.")
+	}
+}
+
+func TestSignedRequestNotReplayed(t *testing.T) {
+	c := make(chan int, 100)
+	key := []byte("my-key")
+	ham := NewHttpAdminManager(&HttpAdminManagerConfig{
+		HmacKey: key,
+		Delay:   time.Hour,
+	})
+	ham.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	sign := func(path string) map[string]string {
+		now := strconv.FormatInt(time.Now().Unix(), 10)
+		return map[string]string{
+			"X-Timestamp": now,
+			"X-Signature": "sha256=" + hex.EncodeToString(Sign(key, "POST", path, now, nil)),
+		}
+	}
+
+	shutdown := sign("/shutdown")
+	if w := request(ham, "POST", "/shutdown", "", shutdown); w.Code != http.StatusAccepted {
+		t.Fatal("Expected 202 for shutdown, got", w.Code)
+	}
+
+	if w := request(ham, "POST", "/abort", "", sign("/abort")); w.Code != http.StatusOK {
+		t.Fatal("Expected 200 for abort, got", w.Code)
+	}
+
+	if w := request(ham, "POST", "/shutdown", "", shutdown); w.Code != http.StatusUnauthorized {
+		t.Error("Expected 401 for replayed shutdown, got", w.Code)
+	}
+
+	if state := ham.Status().State; state != StateRunning {
+		t.Error("Expected running after replay, got", state)
+	}
+}
+
+func TestTokenRequiresBearer(t *testing.T) {
+	ham := NewHttpAdminManager(&HttpAdminManagerConfig{Token: "my-token"})
+	ham.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {}))
+
+	if w := request(ham, "GET", "/status", "", map[string]string{"Authorization": "my-token"}); w.Code != http.StatusUnauthorized {
+		t.Error("Expected 401 for token without Bearer, got", w.Code)
+	}
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	ham := NewHttpAdminManager(&HttpAdminManagerConfig{Token: "my-token"})
+	ham.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {}))
+
+	body := strings.Repeat("x", maxBodySize+1)
+	if w := request(ham, "POST", "/shutdown", body, bearer); w.Code != http.StatusBadRequest {
+		t.Error("Expected 400 for large body, got", w.Code)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	c := make(chan int, 100)
+	ham := NewHttpAdminManager(&HttpAdminManagerConfig{
+		Token: "my-token",
+		Delay: time.Millisecond * 20,
+	})
+	ham.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	w := request(ham, "POST", "/abort", "", bearer)
+	if w.Code != http.StatusConflict {
+		t.Error("Expected 409 without pending shutdown, got", w.Code)
+	}
+
+	request(ham, "POST", "/shutdown", "", bearer)
+
+	if state := ham.Status().State; state != StatePending {
+		t.Error("Expected pending state, got", state)
+	}
+
+	w = request(ham, "POST", "/abort", "", bearer)
+	if w.Code != http.StatusOK {
+		t.Error("Expected 200, got", w.Code)
+	}
+
+	time.Sleep(time.Millisecond * 40)
+	if len(c) != 0 {
+		t.Error("Shutdown started after abort.")
+	}
+
+	if state := ham.Status().State; state != StateRunning {
+		t.Error("Expected running state, got", state)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	gs := gracefulshutdown.New()
+	ham := NewHttpAdminManager(&HttpAdminManagerConfig{Token: "my-token"})
+	ham.Start(gs)
+
+	gs.StartShutdown(ham)
+
+	w := request(ham, "GET", "/status", "", bearer)
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected 200, got", w.Code)
+	}
+
+	status := Status{}
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatal("Invalid status json:", err)
+	}
+
+	if status.State != StateFinished || status.Manager != Name {
+		t.Error("Unexpected status", status)
+	}
+
+	if status.StartedAt.IsZero() || status.FinishedAt.IsZero() {
+		t.Error("Expected start and finish time in status.")
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpadmin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "admin.sock")
+	gs := gracefulshutdown.New()
+	ham := NewHttpAdminManager(&HttpAdminManagerConfig{
+		Network: "unix",
+		Address: socket,
+		Token:   "my-token",
+	})
+	if err := ham.Start(gs); err != nil {
+		t.Fatal("Error in start:", err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		},
+	}}
+
+	req, _ := http.NewRequest("POST", "http://admin/shutdown", nil)
+	req.Header.Set("Authorization", "Bearer my-token")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal("Error in request:", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Error("Expected 202, got", resp.StatusCode)
+	}
+
+	time.Sleep(time.Millisecond * 50)
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Error("Socket should be removed after shutdown.")
+	}
+}
+
+func TestStop(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen:", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	goroutines := testutil.Goroutines()
+
+	c := make(chan int, 100)
+	ham := NewHttpAdminManager(&HttpAdminManagerConfig{
+		Address: addr,
+		Token:   "my-token",
+		Delay:   time.Millisecond * 20,
+	})
+	if err := ham.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	})); err != nil {
+		t.Fatal("Error in start:", err)
+	}
+
+	req, _ := http.NewRequest("POST", "http://"+addr+"/shutdown", nil)
+	req.Header.Set("Authorization", "Bearer my-token")
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal("Error in request:", err)
+	}
+	resp.Body.Close()
+
+	if state := ham.Status().State; state != StatePending {
+		t.Error("Expected pending state, got", state)
+	}
+
+	if err := ham.Stop(); err != nil {
+		t.Error("Error in stop:", err)
+	}
+
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("Expected listener to be closed after Stop.")
+	}
+
+	testutil.WaitGoroutines(t, goroutines)
+
+	time.Sleep(time.Millisecond * 40)
+	if len(c) != 0 {
+		t.Error("Pending shutdown started after Stop.")
+	}
+
+	if err := ham.Health(); err != nil {
+		t.Error("Expected no serve error after Stop, got", err)
+	}
+
+	// Start after Stop serves on the same address again
+	if err := ham.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	})); err != nil {
+		t.Fatal("Error in restart:", err)
+	}
+	defer ham.Stop()
+
+	req, _ = http.NewRequest("POST", "http://"+addr+"/shutdown", nil)
+	req.Header.Set("Authorization", "Bearer my-token")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal("Error in request after restart:", err)
+	}
+	resp.Body.Close()
+
+	select {
+	case <-c:
+	case <-time.After(time.Second):
+		t.Error("Expected shutdown after restart.")
+	}
+}
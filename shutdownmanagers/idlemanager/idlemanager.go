@@ -0,0 +1,172 @@
+/*
+IdleManager starts shutdown when the application has been idle for
+IdleTimeout. The application reports activity with Touch, and marks work
+in progress with Begin and End. Shutdown is only started when no work is
+in progress and nothing was reported for IdleTimeout.
+
+Middleware wraps an http.Handler, so every request counts as work
+in progress.
+
+IdleManager does not exit the process. Use GracefulShutdown.SetExitPolicy,
+so the idle worker does not hold resources while nothing is left to do.
+*/
+package idlemanager
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+const Name = "IdleManager"
+
+// ErrNoIdleTimeout is returned from Start if IdleTimeout is not set.
+var ErrNoIdleTimeout = errors.New("idlemanager: IdleTimeout is required")
+
+// IdleManager implements ShutdownManager interface that is added
+// to GracefulShutdown. Initialize with NewIdleManager.
+type IdleManager struct {
+	gs     gracefulshutdown.GSInterface
+	config *IdleManagerConfig
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mutex        sync.Mutex
+	lastActivity time.Time
+	inFlight     int
+}
+
+// IdleManagerConfig provides configuration options for IdleManager.
+type IdleManagerConfig struct {
+	// IdleTimeout is time without activity after which shutdown is started.
+	IdleTimeout time.Duration
+
+	// CheckInterval is period for checking idle time.
+	// Default is a tenth of IdleTimeout.
+	CheckInterval time.Duration
+}
+
+func (imc *IdleManagerConfig) clean() {
+	if imc.CheckInterval == 0 {
+		imc.CheckInterval = imc.IdleTimeout / 10
+	}
+}
+
+// NewIdleManager initializes the IdleManager. See IdleManagerConfig for
+// configuration options.
+func NewIdleManager(idleManagerConfig *IdleManagerConfig) *IdleManager {
+	if idleManagerConfig == nil {
+		idleManagerConfig = &IdleManagerConfig{}
+	}
+	idleManagerConfig.clean()
+	return &IdleManager{
+		config:       idleManagerConfig,
+		lastActivity: time.Now(),
+	}
+}
+
+// GetName returns name of this ShutdownManager.
+func (idleManager *IdleManager) GetName() string {
+	return Name
+}
+
+// Start starts checking for idle time. Will return error if IdleTimeout
+// is not set.
+func (idleManager *IdleManager) Start(gs gracefulshutdown.GSInterface) error {
+	idleManager.gs = gs
+
+	if idleManager.config.IdleTimeout <= 0 {
+		return ErrNoIdleTimeout
+	}
+
+	idleManager.Touch()
+
+	stop := make(chan struct{})
+	idleManager.stop = stop
+	idleManager.stopOnce = sync.Once{}
+	go idleManager.watch(stop)
+
+	return nil
+}
+
+// Touch reports activity.
+func (idleManager *IdleManager) Touch() {
+	idleManager.mutex.Lock()
+	idleManager.lastActivity = time.Now()
+	idleManager.mutex.Unlock()
+}
+
+// Begin reports start of work. Every Begin has to be followed by End.
+func (idleManager *IdleManager) Begin() {
+	idleManager.mutex.Lock()
+	idleManager.inFlight++
+	idleManager.lastActivity = time.Now()
+	idleManager.mutex.Unlock()
+}
+
+// End reports end of work started with Begin.
+func (idleManager *IdleManager) End() {
+	idleManager.mutex.Lock()
+	idleManager.inFlight--
+	idleManager.lastActivity = time.Now()
+	idleManager.mutex.Unlock()
+}
+
+// Middleware returns http.Handler that reports every request to
+// IdleManager as work in progress.
+func (idleManager *IdleManager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		idleManager.Begin()
+		defer idleManager.End()
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (idleManager *IdleManager) idle() bool {
+	idleManager.mutex.Lock()
+	defer idleManager.mutex.Unlock()
+	return idleManager.inFlight == 0 && time.Since(idleManager.lastActivity) >= idleManager.config.IdleTimeout
+}
+
+func (idleManager *IdleManager) watch(stop <-chan struct{}) {
+	ticker := time.NewTicker(idleManager.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if idleManager.idle() {
+			idleManager.gs.StartShutdown(idleManager)
+			return
+		}
+	}
+}
+
+// Stop stops checking for idleness.
+func (idleManager *IdleManager) Stop() error {
+	idleManager.stopOnce.Do(func() {
+		if idleManager.stop != nil {
+			close(idleManager.stop)
+		}
+	})
+	return nil
+}
+
+// ShutdownStart does nothing.
+func (idleManager *IdleManager) ShutdownStart() error {
+	return nil
+}
+
+// ShutdownFinish does nothing.
+func (idleManager *IdleManager) ShutdownFinish() error {
+	return nil
+}
@@ -0,0 +1,141 @@
+package idlemanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/internal/testutil"
+)
+
+type GSFunc func(sm gracefulshutdown.ShutdownManager)
+
+func (f GSFunc) StartShutdown(sm gracefulshutdown.ShutdownManager) {
+	f(sm)
+}
+
+func (f GSFunc) ReportError(err error) {
+
+}
+
+func (f GSFunc) AddShutdownCallback(shutdownCallback gracefulshutdown.ShutdownCallback) {
+
+}
+
+func waitShutdown(t *testing.T, c <-chan int) {
+	select {
+	case <-c:
+
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for StartShutdown.")
+	}
+}
+
+func startManager(t *testing.T) (*IdleManager, chan int) {
+	c := make(chan int, 100)
+	im := NewIdleManager(&IdleManagerConfig{
+		IdleTimeout:   time.Millisecond * 30,
+		CheckInterval: time.Millisecond,
+	})
+	if err := im.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	})); err != nil {
+		t.Fatal("Error in start:", err)
+	}
+	return im, c
+}
+
+func TestStartRequiresIdleTimeout(t *testing.T) {
+	im := NewIdleManager(nil)
+
+	if err := im.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {})); err != ErrNoIdleTimeout {
+		t.Error("Expected ErrNoIdleTimeout, got", err)
+	}
+}
+
+func TestIdle(t *testing.T) {
+	start := time.Now()
+	_, c := startManager(t)
+
+	waitShutdown(t, c)
+
+	if elapsed := time.Since(start); elapsed < time.Millisecond*30 {
+		t.Error("Shutdown started before idle timeout, after", elapsed)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	im, c := startManager(t)
+
+	for i := 0; i < 6; i++ {
+		time.Sleep(time.Millisecond * 10)
+		im.Touch()
+	}
+
+	if len(c) != 0 {
+		t.Fatal("Shutdown started while touched.")
+	}
+
+	waitShutdown(t, c)
+}
+
+func TestInFlight(t *testing.T) {
+	im, c := startManager(t)
+
+	im.Begin()
+	time.Sleep(time.Millisecond * 60)
+
+	if len(c) != 0 {
+		t.Fatal("Shutdown started with work in progress.")
+	}
+
+	im.End()
+	waitShutdown(t, c)
+}
+
+func TestMiddleware(t *testing.T) {
+	im, c := startManager(t)
+
+	handler := im.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(time.Millisecond * 60)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusTeapot {
+		t.Error("Middleware did not call handler.")
+	}
+
+	if len(c) != 0 {
+		t.Fatal("Shutdown started during request.")
+	}
+
+	waitShutdown(t, c)
+}
+
+func TestStop(t *testing.T) {
+	goroutines := testutil.Goroutines()
+	im, c := startManager(t)
+
+	if err := im.Stop(); err != nil {
+		t.Error("Error in stop:", err)
+	}
+	im.Stop()
+	testutil.WaitGoroutines(t, goroutines)
+
+	time.Sleep(time.Millisecond * 50)
+	if len(c) != 0 {
+		t.Error("Shutdown started after Stop.")
+	}
+
+	im.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+	defer im.Stop()
+	waitShutdown(t, c)
+}
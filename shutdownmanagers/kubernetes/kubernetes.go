@@ -0,0 +1,298 @@
+/*
+KubernetesManager provides an http endpoint for Kubernetes preStop hook.
+A request to /prestop starts shutdown and blocks until all callbacks finish
+or until PreStopFraction of TerminationGracePeriod passes, so the pod
+drains before it receives SIGTERM. It also serves /readyz, which starts
+failing as soon as shutdown is requested by any ShutdownManager, and with
+CheckHealth also when any ShutdownManager is unhealthy.
+
+Pod spec should point the hooks to the port of KubernetesManager:
+
+	lifecycle:
+	  preStop:
+	    httpGet:
+	      path: /prestop
+	      port: 8090
+	readinessProbe:
+	  httpGet:
+	    path: /readyz
+	    port: 8090
+*/
+package kubernetes
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+const (
+	Name = "KubernetesManager"
+
+	defaultTerminationGracePeriod = time.Second * 30
+	defaultPreStopFraction        = 0.8
+	defaultBackOff                = 500.0
+	defaultServeRetries           = 20
+)
+
+// KubernetesManager implements ShutdownManager interface that is added
+// to GracefulShutdown. Initialize with NewKubernetesManager.
+type KubernetesManager struct {
+	gs       gracefulshutdown.GSInterface
+	config   *KubernetesManagerConfig
+	mux      *http.ServeMux
+	listener net.Listener
+
+	mutex        sync.Mutex
+	shuttingDown bool
+	httpErr      error
+	done         chan struct{}
+	stop         chan struct{}
+	stopOnce     sync.Once
+}
+
+// KubernetesManagerConfig provides configuration options for KubernetesManager.
+type KubernetesManagerConfig struct {
+	// Port on which to listen for preStop and readiness requests.
+	Port uint16
+
+	// TerminationGracePeriod should match terminationGracePeriodSeconds
+	// of the pod. Default is 30 seconds.
+	TerminationGracePeriod time.Duration
+
+	// PreStopFraction is the part of TerminationGracePeriod preStop
+	// request will wait for callbacks to finish. Default is 0.8.
+	PreStopFraction float64
+
+	// BackOff is time for backup when retrying http listener
+	BackOff float64
+
+	// NumServeRetries is number of retries for http listener
+	// -1 for no retries, 0 is default value
+	NumServeRetries int
+
+	// CheckHealth makes readiness fail when Health of GracefulShutdown
+	// returns an error, so the pod is not ready while a shutdown request
+	// could be missed.
+	CheckHealth bool
+}
+
+func (kmc *KubernetesManagerConfig) clean() {
+	if kmc.TerminationGracePeriod == 0 {
+		kmc.TerminationGracePeriod = defaultTerminationGracePeriod
+	}
+	if kmc.PreStopFraction <= 0 || kmc.PreStopFraction > 1 {
+		kmc.PreStopFraction = defaultPreStopFraction
+	}
+	if kmc.BackOff == 0 {
+		kmc.BackOff = defaultBackOff
+	}
+	if kmc.NumServeRetries == 0 {
+		kmc.NumServeRetries = defaultServeRetries
+	} else if kmc.NumServeRetries < 0 {
+		kmc.NumServeRetries = 0
+	}
+}
+
+// NewKubernetesManager initializes the KubernetesManager. See
+// KubernetesManagerConfig for configuration options.
+func NewKubernetesManager(kubernetesManagerConfig *KubernetesManagerConfig) *KubernetesManager {
+	if kubernetesManagerConfig == nil {
+		kubernetesManagerConfig = &KubernetesManagerConfig{}
+	}
+	kubernetesManagerConfig.clean()
+
+	kubernetesManager := &KubernetesManager{
+		config: kubernetesManagerConfig,
+		mux:    http.NewServeMux(),
+		done:   make(chan struct{}),
+	}
+	kubernetesManager.mux.HandleFunc("/prestop", kubernetesManager.servePreStop)
+	kubernetesManager.mux.HandleFunc("/readyz", kubernetesManager.serveReady)
+	return kubernetesManager
+}
+
+// GetName returns name of this ShutdownManager.
+func (kubernetesManager *KubernetesManager) GetName() string {
+	return Name
+}
+
+// Start starts listening for preStop and readiness requests over http.
+func (kubernetesManager *KubernetesManager) Start(gs gracefulshutdown.GSInterface) error {
+	kubernetesManager.gs = gs
+
+	kubernetesManager.gs.AddShutdownCallback(kubernetesManager)
+
+	if kubernetesManager.config.Port != 0 {
+		stop := make(chan struct{})
+		kubernetesManager.mutex.Lock()
+		kubernetesManager.stop = stop
+		kubernetesManager.stopOnce = sync.Once{}
+		kubernetesManager.mutex.Unlock()
+		go kubernetesManager.listenHTTP(stop)
+	}
+
+	return nil
+}
+
+// OnShutdown makes readiness fail.
+func (kubernetesManager *KubernetesManager) OnShutdown(shutdownManager string) error {
+	kubernetesManager.setShuttingDown()
+	return nil
+}
+
+// ServeHTTP serves /prestop and /readyz endpoints. It can be used to
+// add the endpoints to an existing http server when Port is 0.
+func (kubernetesManager *KubernetesManager) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	kubernetesManager.mux.ServeHTTP(w, req)
+}
+
+func (kubernetesManager *KubernetesManager) servePreStop(w http.ResponseWriter, req *http.Request) {
+	kubernetesManager.setShuttingDown()
+	go kubernetesManager.gs.StartShutdown(kubernetesManager)
+
+	// if another ShutdownManager started shutdown, ShutdownFinish of
+	// this one is not called, so wait for GracefulShutdown instead
+	var gsDone <-chan struct{}
+	if doneNotifier, ok := kubernetesManager.gs.(gracefulshutdown.DoneNotifier); ok {
+		gsDone = doneNotifier.Done()
+	}
+
+	timeout := time.NewTimer(time.Duration(float64(kubernetesManager.config.TerminationGracePeriod) * kubernetesManager.config.PreStopFraction))
+	defer timeout.Stop()
+
+	select {
+	case <-kubernetesManager.done:
+		w.WriteHeader(http.StatusOK)
+	case <-gsDone:
+		w.WriteHeader(http.StatusOK)
+	case <-timeout.C:
+		w.WriteHeader(http.StatusAccepted)
+	case <-req.Context().Done():
+	}
+}
+
+func (kubernetesManager *KubernetesManager) serveReady(w http.ResponseWriter, req *http.Request) {
+	if kubernetesManager.isShuttingDown() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if healthChecker, ok := kubernetesManager.gs.(gracefulshutdown.HealthChecker); ok && kubernetesManager.config.CheckHealth {
+		if err := healthChecker.Health(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// setShuttingDown makes readiness fail.
+func (kubernetesManager *KubernetesManager) setShuttingDown() {
+	kubernetesManager.mutex.Lock()
+	defer kubernetesManager.mutex.Unlock()
+	kubernetesManager.shuttingDown = true
+}
+
+func (kubernetesManager *KubernetesManager) isShuttingDown() bool {
+	kubernetesManager.mutex.Lock()
+	defer kubernetesManager.mutex.Unlock()
+	return kubernetesManager.shuttingDown
+}
+
+func (kubernetesManager *KubernetesManager) listenHTTP(stop <-chan struct{}) {
+	var listener net.Listener
+	var err error
+
+	for i := 0; i < kubernetesManager.config.NumServeRetries+1; i++ {
+		listener, err = net.Listen("tcp", fmt.Sprintf(":%d", kubernetesManager.config.Port))
+		if err == nil {
+			break
+		}
+
+		select {
+		case <-time.After(kubernetesManager.backOffDuration(i)):
+		case <-stop:
+			return
+		}
+	}
+	if err != nil {
+		kubernetesManager.mutex.Lock()
+		kubernetesManager.httpErr = err
+		kubernetesManager.mutex.Unlock()
+
+		kubernetesManager.gs.ReportError(gracefulshutdown.NewError(Name, "listen", gracefulshutdown.SeverityCritical, err))
+		return
+	}
+
+	kubernetesManager.mutex.Lock()
+	select {
+	case <-stop:
+		kubernetesManager.mutex.Unlock()
+		listener.Close()
+		return
+	default:
+	}
+	kubernetesManager.listener = listener
+	kubernetesManager.mutex.Unlock()
+
+	err = http.Serve(listener, kubernetesManager)
+
+	// listener is set to nil when it is closed by Stop
+	kubernetesManager.mutex.Lock()
+	if kubernetesManager.listener == listener {
+		kubernetesManager.httpErr = err
+	}
+	kubernetesManager.mutex.Unlock()
+}
+
+func (kubernetesManager *KubernetesManager) backOffDuration(i int) time.Duration {
+	rand := rand.Float64() + 0.5
+	try := float64(i + 1)
+	return time.Duration(kubernetesManager.config.BackOff*try*rand) * time.Millisecond
+}
+
+// Stop stops listening for http requests.
+func (kubernetesManager *KubernetesManager) Stop() error {
+	kubernetesManager.mutex.Lock()
+	defer kubernetesManager.mutex.Unlock()
+
+	kubernetesManager.stopOnce.Do(func() {
+		if kubernetesManager.stop != nil {
+			close(kubernetesManager.stop)
+		}
+	})
+	if kubernetesManager.listener != nil {
+		err := kubernetesManager.listener.Close()
+		kubernetesManager.listener = nil
+		return err
+	}
+	return nil
+}
+
+// Health returns an error if http listener failed, so preStop requests
+// are not received.
+func (kubernetesManager *KubernetesManager) Health() error {
+	kubernetesManager.mutex.Lock()
+	defer kubernetesManager.mutex.Unlock()
+
+	if kubernetesManager.httpErr != nil {
+		return fmt.Errorf("http listener failed: %w", kubernetesManager.httpErr)
+	}
+	return nil
+}
+
+// ShutdownStart does nothing.
+func (kubernetesManager *KubernetesManager) ShutdownStart() error {
+	return nil
+}
+
+// ShutdownFinish releases the blocked preStop request.
+func (kubernetesManager *KubernetesManager) ShutdownFinish() error {
+	close(kubernetesManager.done)
+	return nil
+}
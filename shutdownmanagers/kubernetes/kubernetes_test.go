@@ -0,0 +1,266 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/internal/testutil"
+)
+
+func serve(km *KubernetesManager, path string) int {
+	req, _ := http.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	km.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestNewKubernetesManager(t *testing.T) {
+	km := NewKubernetesManager(nil)
+
+	if km.config.TerminationGracePeriod != defaultTerminationGracePeriod {
+		t.Error("Default termination grace period not set.")
+	}
+
+	if km.config.PreStopFraction != defaultPreStopFraction {
+		t.Error("Default preStop fraction not set.")
+	}
+}
+
+func TestPreStopWaitsForCallbacks(t *testing.T) {
+	c := make(chan int, 100)
+	gs := gracefulshutdown.New()
+	gs.AddShutdownCallback(gracefulshutdown.ShutdownFunc(func(shutdownManager string) error {
+		time.Sleep(time.Millisecond * 20)
+		if shutdownManager == Name {
+			c <- 1
+		}
+		return nil
+	}))
+
+	km := NewKubernetesManager(&KubernetesManagerConfig{
+		TerminationGracePeriod: time.Second,
+	})
+	km.Start(gs)
+
+	if code := serve(km, "/prestop"); code != http.StatusOK {
+		t.Error("Expected preStop to return 200, got", code)
+	}
+
+	if len(c) != 1 {
+		t.Error("PreStop returned before callbacks finished.")
+	}
+
+	if code := serve(km, "/prestop"); code != http.StatusOK {
+		t.Error("Expected repeated preStop to return 200, got", code)
+	}
+}
+
+func TestPreStopTimeout(t *testing.T) {
+	gs := gracefulshutdown.New()
+	gs.AddShutdownCallback(gracefulshutdown.ShutdownFunc(func(string) error {
+		time.Sleep(time.Second)
+		return nil
+	}))
+
+	km := NewKubernetesManager(&KubernetesManagerConfig{
+		TerminationGracePeriod: time.Millisecond * 20,
+		PreStopFraction:        0.5,
+	})
+	km.Start(gs)
+
+	start := time.Now()
+	if code := serve(km, "/prestop"); code != http.StatusAccepted {
+		t.Error("Expected preStop to return 202, got", code)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Millisecond*500 {
+		t.Error("PreStop did not time out, took", elapsed)
+	}
+}
+
+func TestPreStopAfterShutdownStarted(t *testing.T) {
+	c := make(chan string, 100)
+	gs := gracefulshutdown.New()
+	gs.AddShutdownCallback(gracefulshutdown.ShutdownFunc(func(shutdownManager string) error {
+		time.Sleep(time.Millisecond * 20)
+		c <- shutdownManager
+		return nil
+	}))
+
+	km := NewKubernetesManager(&KubernetesManagerConfig{
+		TerminationGracePeriod: time.Second,
+	})
+	gs.AddShutdownManager(km)
+	gs.Start()
+
+	go gs.StartShutdown(NewKubernetesManager(nil))
+	time.Sleep(time.Millisecond * 5)
+
+	if code := serve(km, "/prestop"); code != http.StatusOK {
+		t.Error("Expected preStop to return 200, got", code)
+	}
+
+	time.Sleep(time.Millisecond * 50)
+	if len(c) != 1 {
+		t.Error("Expected callbacks to run once, got", len(c))
+	}
+}
+
+func TestPreStopRequestCancelled(t *testing.T) {
+	gs := gracefulshutdown.New()
+	gs.AddShutdownCallback(gracefulshutdown.ShutdownFunc(func(string) error {
+		time.Sleep(time.Second)
+		return nil
+	}))
+
+	km := NewKubernetesManager(&KubernetesManagerConfig{
+		TerminationGracePeriod: time.Minute,
+	})
+	km.Start(gs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/prestop", nil)
+
+	start := time.Now()
+	km.ServeHTTP(httptest.NewRecorder(), req)
+	if elapsed := time.Since(start); elapsed > time.Millisecond*500 {
+		t.Error("PreStop did not return when request was cancelled, took", elapsed)
+	}
+}
+
+func TestReadinessFailsOnShutdown(t *testing.T) {
+	gs := gracefulshutdown.New()
+	km := NewKubernetesManager(nil)
+	km.Start(gs)
+
+	if code := serve(km, "/readyz"); code != http.StatusOK {
+		t.Error("Expected readiness to return 200, got", code)
+	}
+
+	gs.StartShutdown(NewKubernetesManager(nil))
+
+	if code := serve(km, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Error("Expected readiness to return 503, got", code)
+	}
+}
+
+type unhealthyManager struct {
+	*KubernetesManager
+}
+
+func (um unhealthyManager) Health() error {
+	return errors.New("listener-dead")
+}
+
+func TestReadinessFailsWhenUnhealthy(t *testing.T) {
+	gs := gracefulshutdown.New()
+	km := NewKubernetesManager(&KubernetesManagerConfig{CheckHealth: true})
+	gs.AddShutdownManager(km)
+	gs.Start()
+
+	if code := serve(km, "/readyz"); code != http.StatusOK {
+		t.Error("Expected readiness to return 200, got", code)
+	}
+
+	gs.AddShutdownManager(unhealthyManager{NewKubernetesManager(nil)})
+
+	if code := serve(km, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Error("Expected readiness to return 503, got", code)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen:", err)
+	}
+	defer listener.Close()
+
+	km := NewKubernetesManager(&KubernetesManagerConfig{
+		Port:            uint16(listener.Addr().(*net.TCPAddr).Port),
+		NumServeRetries: -1,
+	})
+	km.gs = gracefulshutdown.New()
+	km.listenHTTP(make(chan struct{}))
+
+	if err := km.Health(); err == nil {
+		t.Error("Expected http listener error.")
+	}
+}
+
+// freePort returns a port that was free a moment ago.
+func freePort(t *testing.T) uint16 {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen:", err)
+	}
+	defer listener.Close()
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestStop(t *testing.T) {
+	port := freePort(t)
+	goroutines := testutil.Goroutines()
+
+	km := NewKubernetesManager(&KubernetesManagerConfig{Port: port})
+	km.Start(gracefulshutdown.New())
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	testutil.WaitListening(t, addr)
+
+	if err := km.Stop(); err != nil {
+		t.Error("Error in stop:", err)
+	}
+	if err := km.Stop(); err != nil {
+		t.Error("Error in second stop:", err)
+	}
+
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("Expected listener to be closed after Stop.")
+	}
+
+	testutil.WaitGoroutines(t, goroutines)
+
+	// Start after Stop listens on the same port again
+	km.Start(gracefulshutdown.New())
+	defer km.Stop()
+	testutil.WaitListening(t, addr)
+
+	if code := serve(km, "/readyz"); code != http.StatusOK {
+		t.Error("Expected ready after restart, got", code)
+	}
+}
+
+func TestStopWhileRetrying(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal("Listen:", err)
+	}
+	defer listener.Close()
+
+	goroutines := testutil.Goroutines()
+
+	km := NewKubernetesManager(&KubernetesManagerConfig{
+		Port:    uint16(listener.Addr().(*net.TCPAddr).Port),
+		BackOff: 10,
+	})
+	km.Start(gracefulshutdown.New())
+
+	time.Sleep(time.Millisecond * 20)
+	km.Stop()
+
+	testutil.WaitGoroutines(t, goroutines)
+
+	if err := km.Health(); err != nil {
+		t.Error("Expected no listener error after Stop, got", err)
+	}
+}
@@ -0,0 +1,198 @@
+/*
+LifetimeManager starts shutdown after the process has been running for
+MaxLifetime. A random part of Jitter is subtracted from MaxLifetime for
+every process, so a fleet started at the same time does not restart
+at the same time.
+
+If MaxConcurrent is set, at most that many processes on the host will
+run lifetime shutdown at the same time. Processes coordinate through lock
+files in LockDir. A process that can not get a lock when its lifetime
+expires waits and retries every RetryInterval. The lock is released when
+shutdown finishes or when the process exits.
+
+When shutdown finishes, the lock is released. LifetimeManager does not
+exit the process. Use GracefulShutdown.SetExitPolicy, so its supervisor
+starts a fresh one.
+*/
+package lifetimemanager
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+const (
+	Name = "LifetimeManager"
+
+	defaultRetryInterval = time.Second * 10
+)
+
+// ErrNoLifetime is returned from Start if MaxLifetime is not set.
+var ErrNoLifetime = errors.New("lifetimemanager: MaxLifetime is required")
+
+// ErrJitter is returned from Start if Jitter is not shorter than
+// MaxLifetime, so the lifetime could be 0 or negative.
+var ErrJitter = errors.New("lifetimemanager: Jitter must be shorter than MaxLifetime")
+
+var errLockUnsupported = errors.New("lifetimemanager: MaxConcurrent is not supported on this platform")
+
+// LifetimeManager implements ShutdownManager interface that is added
+// to GracefulShutdown. Initialize with NewLifetimeManager.
+type LifetimeManager struct {
+	gs       gracefulshutdown.GSInterface
+	config   *LifetimeManagerConfig
+	lifetime time.Duration
+	lock     *os.File
+	timer    *time.Timer
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// LifetimeManagerConfig provides configuration options for LifetimeManager.
+type LifetimeManagerConfig struct {
+	// MaxLifetime is time after Start when shutdown is started.
+	MaxLifetime time.Duration
+
+	// Jitter is maximum random time subtracted from MaxLifetime.
+	Jitter time.Duration
+
+	// MaxConcurrent is maximum number of processes on the host that run
+	// lifetime shutdown at the same time. 0 means no limit.
+	MaxConcurrent int
+
+	// LockDir is directory for lock files. All processes that share the
+	// limit have to use the same directory.
+	// Default is gracefulshutdown-lifetime in os.TempDir().
+	LockDir string
+
+	// RetryInterval is period for retrying to get a lock.
+	// Default is 10 seconds.
+	RetryInterval time.Duration
+}
+
+func (lmc *LifetimeManagerConfig) clean() {
+	if lmc.LockDir == "" {
+		lmc.LockDir = filepath.Join(os.TempDir(), "gracefulshutdown-lifetime")
+	}
+	if lmc.RetryInterval == 0 {
+		lmc.RetryInterval = defaultRetryInterval
+	}
+}
+
+// NewLifetimeManager initializes the LifetimeManager. See
+// LifetimeManagerConfig for configuration options.
+func NewLifetimeManager(lifetimeManagerConfig *LifetimeManagerConfig) *LifetimeManager {
+	if lifetimeManagerConfig == nil {
+		lifetimeManagerConfig = &LifetimeManagerConfig{}
+	}
+	lifetimeManagerConfig.clean()
+
+	lifetime := lifetimeManagerConfig.MaxLifetime
+	if lifetimeManagerConfig.Jitter > 0 {
+		lifetime -= time.Duration(rand.Int63n(int64(lifetimeManagerConfig.Jitter)))
+	}
+
+	return &LifetimeManager{
+		config:   lifetimeManagerConfig,
+		lifetime: lifetime,
+	}
+}
+
+// GetName returns name of this ShutdownManager.
+func (lifetimeManager *LifetimeManager) GetName() string {
+	return Name
+}
+
+// Lifetime returns time after Start when shutdown will be started,
+// with jitter applied.
+func (lifetimeManager *LifetimeManager) Lifetime() time.Duration {
+	return lifetimeManager.lifetime
+}
+
+// Start starts the lifetime timer. Will return error if MaxLifetime is not
+// set, if Jitter is not shorter than MaxLifetime or if LockDir can not
+// be created. MaxConcurrent is not supported on
+// windows.
+func (lifetimeManager *LifetimeManager) Start(gs gracefulshutdown.GSInterface) error {
+	lifetimeManager.gs = gs
+
+	if lifetimeManager.config.MaxLifetime <= 0 {
+		return ErrNoLifetime
+	}
+
+	if lifetimeManager.config.Jitter >= lifetimeManager.config.MaxLifetime {
+		return ErrJitter
+	}
+
+	if lifetimeManager.config.MaxConcurrent > 0 {
+		if !lockSupported {
+			return errLockUnsupported
+		}
+		if err := os.MkdirAll(lifetimeManager.config.LockDir, 0777); err != nil {
+			return err
+		}
+	}
+
+	stop := make(chan struct{})
+	lifetimeManager.stop = stop
+	lifetimeManager.stopOnce = sync.Once{}
+	lifetimeManager.timer = time.AfterFunc(lifetimeManager.lifetime, func() {
+		lifetimeManager.expire(stop)
+	})
+
+	return nil
+}
+
+func (lifetimeManager *LifetimeManager) expire(stop <-chan struct{}) {
+	if lifetimeManager.config.MaxConcurrent > 0 {
+		for {
+			lock, err := tryLock(lifetimeManager.config.LockDir, lifetimeManager.config.MaxConcurrent)
+			lifetimeManager.gs.ReportError(gracefulshutdown.NewError(Name, "lock", gracefulshutdown.SeverityWarning, err))
+			if lock != nil {
+				lifetimeManager.lock = lock
+				break
+			}
+			select {
+			case <-time.After(lifetimeManager.config.RetryInterval):
+			case <-stop:
+				return
+			}
+		}
+	}
+
+	lifetimeManager.gs.StartShutdown(lifetimeManager)
+}
+
+// Stop stops the lifetime timer.
+func (lifetimeManager *LifetimeManager) Stop() error {
+	if lifetimeManager.timer != nil {
+		lifetimeManager.timer.Stop()
+	}
+	lifetimeManager.stopOnce.Do(func() {
+		if lifetimeManager.stop != nil {
+			close(lifetimeManager.stop)
+		}
+	})
+	return nil
+}
+
+// ShutdownStart does nothing.
+func (lifetimeManager *LifetimeManager) ShutdownStart() error {
+	return nil
+}
+
+// ShutdownFinish releases the lock, so another process can start
+// lifetime shutdown.
+func (lifetimeManager *LifetimeManager) ShutdownFinish() error {
+	if lifetimeManager.lock != nil {
+		return lifetimeManager.lock.Close()
+	}
+	return nil
+}
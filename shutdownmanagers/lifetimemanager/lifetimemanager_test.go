@@ -0,0 +1,205 @@
+package lifetimemanager
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/internal/testutil"
+)
+
+type GSFunc func(sm gracefulshutdown.ShutdownManager)
+
+func (f GSFunc) StartShutdown(sm gracefulshutdown.ShutdownManager) {
+	f(sm)
+}
+
+func (f GSFunc) ReportError(err error) {
+
+}
+
+func (f GSFunc) AddShutdownCallback(shutdownCallback gracefulshutdown.ShutdownCallback) {
+
+}
+
+func waitShutdown(t *testing.T, c <-chan int) {
+	select {
+	case <-c:
+
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for StartShutdown.")
+	}
+}
+
+func TestStartRequiresLifetime(t *testing.T) {
+	lm := NewLifetimeManager(nil)
+
+	if err := lm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {})); err != ErrNoLifetime {
+		t.Error("Expected ErrNoLifetime, got", err)
+	}
+}
+
+func TestStartRejectsJitter(t *testing.T) {
+	c := make(chan int, 100)
+	lm := NewLifetimeManager(&LifetimeManagerConfig{
+		MaxLifetime: time.Minute,
+		Jitter:      time.Minute,
+	})
+
+	if err := lm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) { c <- 1 })); err != ErrJitter {
+		t.Error("Expected ErrJitter, got", err)
+	}
+
+	time.Sleep(time.Millisecond * 10)
+	if len(c) != 0 {
+		t.Error("Shutdown started with rejected jitter.")
+	}
+}
+
+func TestJitter(t *testing.T) {
+	different := false
+	for i := 0; i < 20; i++ {
+		lm := NewLifetimeManager(&LifetimeManagerConfig{
+			MaxLifetime: time.Hour,
+			Jitter:      time.Minute * 10,
+		})
+
+		if lm.Lifetime() > time.Hour || lm.Lifetime() <= time.Minute*50 {
+			t.Error("Lifetime out of jitter range:", lm.Lifetime())
+		}
+
+		if lm.Lifetime() != time.Hour {
+			different = true
+		}
+	}
+
+	if !different {
+		t.Error("Jitter was never applied.")
+	}
+}
+
+func TestShutdownAfterLifetime(t *testing.T) {
+	c := make(chan int, 100)
+	lm := NewLifetimeManager(&LifetimeManagerConfig{
+		MaxLifetime: time.Millisecond * 20,
+	})
+
+	start := time.Now()
+	lm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	waitShutdown(t, c)
+
+	if elapsed := time.Since(start); elapsed < time.Millisecond*20 {
+		t.Error("Shutdown started before lifetime, after", elapsed)
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lifetimemanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := func() *LifetimeManagerConfig {
+		return &LifetimeManagerConfig{
+			MaxLifetime:   time.Millisecond,
+			MaxConcurrent: 1,
+			LockDir:       dir,
+			RetryInterval: time.Millisecond * 5,
+		}
+	}
+
+	first := make(chan int, 100)
+	lm1 := NewLifetimeManager(config())
+	lm1.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		first <- 1
+	}))
+	waitShutdown(t, first)
+
+	second := make(chan int, 100)
+	lm2 := NewLifetimeManager(config())
+	lm2.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		second <- 1
+	}))
+
+	time.Sleep(time.Millisecond * 30)
+	if len(second) != 0 {
+		t.Fatal("Second shutdown started while first holds the lock.")
+	}
+
+	lm1.ShutdownFinish()
+	waitShutdown(t, second)
+	lm2.ShutdownFinish()
+}
+
+func TestStop(t *testing.T) {
+	c := make(chan int, 100)
+	lm := NewLifetimeManager(&LifetimeManagerConfig{
+		MaxLifetime: time.Millisecond * 20,
+	})
+	lm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	if err := lm.Stop(); err != nil {
+		t.Error("Error in stop:", err)
+	}
+	lm.Stop()
+
+	time.Sleep(time.Millisecond * 40)
+	if len(c) != 0 {
+		t.Error("Shutdown started after Stop.")
+	}
+
+	lm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+	defer lm.Stop()
+	waitShutdown(t, c)
+}
+
+func TestStopWhileWaitingForLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lifetimemanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := func() *LifetimeManagerConfig {
+		return &LifetimeManagerConfig{
+			MaxLifetime:   time.Millisecond,
+			MaxConcurrent: 1,
+			LockDir:       dir,
+			RetryInterval: time.Millisecond * 5,
+		}
+	}
+
+	first := make(chan int, 100)
+	lm1 := NewLifetimeManager(config())
+	lm1.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		first <- 1
+	}))
+	waitShutdown(t, first)
+
+	goroutines := testutil.Goroutines()
+	second := make(chan int, 100)
+	lm2 := NewLifetimeManager(config())
+	lm2.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		second <- 1
+	}))
+
+	time.Sleep(time.Millisecond * 20)
+	lm2.Stop()
+	testutil.WaitGoroutines(t, goroutines)
+
+	lm1.ShutdownFinish()
+	time.Sleep(time.Millisecond * 20)
+	if len(second) != 0 {
+		t.Error("Shutdown started after Stop.")
+	}
+}
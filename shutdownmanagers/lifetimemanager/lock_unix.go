@@ -0,0 +1,36 @@
+//go:build !windows
+// +build !windows
+
+package lifetimemanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const lockSupported = true
+
+// tryLock tries to get an exclusive lock on one of slots lock files in dir.
+// Returns nil if all slots are taken. The lock is released when the
+// returned file is closed.
+func tryLock(dir string, slots int) (*os.File, error) {
+	for i := 0; i < slots; i++ {
+		file, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("slot-%d.lock", i)), os.O_CREATE|os.O_RDWR, 0666)
+		if err != nil {
+			return nil, err
+		}
+
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return file, nil
+		}
+		file.Close()
+
+		if err != syscall.EWOULDBLOCK {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package lifetimemanager
+
+import (
+	"os"
+)
+
+const lockSupported = false
+
+func tryLock(dir string, slots int) (*os.File, error) {
+	return nil, errLockUnsupported
+}
@@ -0,0 +1,173 @@
+/*
+ParentDeathManager starts shutdown when the parent process dies, so
+sidecar and helper processes do not stay around as orphans.
+On Linux it asks the kernel to send DeathSignal when the parent dies
+with PR_SET_PDEATHSIG. On all platforms it also polls the parent pid
+and starts shutdown when the process gets reparented.
+
+ParentDeathManager does not exit the process. Use
+GracefulShutdown.SetExitPolicy, so the process is not left running
+without its parent once shutdown finishes.
+*/
+package parentdeath
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+const (
+	Name = "ParentDeathManager"
+
+	defaultPollInterval = time.Second
+)
+
+// ParentDeathManager implements ShutdownManager interface that is added
+// to GracefulShutdown. Initialize with NewParentDeathManager.
+type ParentDeathManager struct {
+	gs     gracefulshutdown.GSInterface
+	config *ParentDeathManagerConfig
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	ppid int
+	once sync.Once
+}
+
+// ParentDeathManagerConfig provides configuration options for ParentDeathManager.
+type ParentDeathManagerConfig struct {
+	// PollInterval is period for checking parent pid. Default is 1 second.
+	// -1 disables polling.
+	PollInterval time.Duration
+
+	// DeathSignal is the signal requested with PR_SET_PDEATHSIG. It should
+	// not be a signal handled elsewhere in the application.
+	// Default is SIGUSR2. Ignored on platforms without PR_SET_PDEATHSIG.
+	DeathSignal syscall.Signal
+}
+
+func (pdmc *ParentDeathManagerConfig) clean() {
+	if pdmc.PollInterval == 0 {
+		pdmc.PollInterval = defaultPollInterval
+	}
+	if pdmc.DeathSignal == 0 {
+		pdmc.DeathSignal = defaultDeathSignal
+	}
+}
+
+// NewParentDeathManager initializes the ParentDeathManager. See
+// ParentDeathManagerConfig for configuration options.
+func NewParentDeathManager(parentDeathManagerConfig *ParentDeathManagerConfig) *ParentDeathManager {
+	if parentDeathManagerConfig == nil {
+		parentDeathManagerConfig = &ParentDeathManagerConfig{}
+	}
+	parentDeathManagerConfig.clean()
+	return &ParentDeathManager{
+		config: parentDeathManagerConfig,
+	}
+}
+
+// GetName returns name of this ShutdownManager.
+func (parentDeathManager *ParentDeathManager) GetName() string {
+	return Name
+}
+
+// Start starts watching for death of the parent process. Will return error
+// if PR_SET_PDEATHSIG fails.
+func (parentDeathManager *ParentDeathManager) Start(gs gracefulshutdown.GSInterface) error {
+	parentDeathManager.gs = gs
+	parentDeathManager.ppid = os.Getppid()
+	stop := make(chan struct{})
+	parentDeathManager.stop = stop
+	parentDeathManager.stopOnce = sync.Once{}
+
+	if deathSignalSupported {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, parentDeathManager.config.DeathSignal)
+
+		if err := setDeathSignal(parentDeathManager.config.DeathSignal); err != nil {
+			signal.Stop(c)
+			return err
+		}
+
+		go func() {
+			select {
+			case <-c:
+				parentDeathManager.startShutdown()
+			case <-stop:
+				signal.Stop(c)
+			}
+		}()
+	}
+
+	// parent could have died before death signal was set
+	if parentDeathManager.parentDied() {
+		go parentDeathManager.startShutdown()
+		return nil
+	}
+
+	if parentDeathManager.config.PollInterval > 0 {
+		go parentDeathManager.poll(stop)
+	}
+
+	return nil
+}
+
+func (parentDeathManager *ParentDeathManager) parentDied() bool {
+	return os.Getppid() != parentDeathManager.ppid
+}
+
+func (parentDeathManager *ParentDeathManager) poll(stop <-chan struct{}) {
+	ticker := time.NewTicker(parentDeathManager.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if parentDeathManager.parentDied() {
+			parentDeathManager.startShutdown()
+			return
+		}
+	}
+}
+
+func (parentDeathManager *ParentDeathManager) startShutdown() {
+	parentDeathManager.once.Do(func() {
+		parentDeathManager.gs.StartShutdown(parentDeathManager)
+	})
+}
+
+// Stop stops watching for death of the parent process and clears
+// the death signal.
+func (parentDeathManager *ParentDeathManager) Stop() error {
+	var err error
+	if deathSignalSupported {
+		err = setDeathSignal(0)
+	}
+	parentDeathManager.stopOnce.Do(func() {
+		if parentDeathManager.stop != nil {
+			close(parentDeathManager.stop)
+		}
+	})
+	return err
+}
+
+// ShutdownStart does nothing.
+func (parentDeathManager *ParentDeathManager) ShutdownStart() error {
+	return nil
+}
+
+// ShutdownFinish does nothing.
+func (parentDeathManager *ParentDeathManager) ShutdownFinish() error {
+	return nil
+}
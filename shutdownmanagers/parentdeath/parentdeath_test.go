@@ -0,0 +1,175 @@
+package parentdeath
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/internal/testutil"
+)
+
+type GSFunc func(sm gracefulshutdown.ShutdownManager)
+
+func (f GSFunc) StartShutdown(sm gracefulshutdown.ShutdownManager) {
+	f(sm)
+}
+
+func (f GSFunc) ReportError(err error) {
+
+}
+
+func (f GSFunc) AddShutdownCallback(shutdownCallback gracefulshutdown.ShutdownCallback) {
+
+}
+
+// TestMain runs the test binary as helper process when GS_HELPER is set.
+// Parent helper starts child helper and exits once child is ready.
+// Child helper runs ParentDeathManager and records shutdown in GS_OUT.
+func TestMain(m *testing.M) {
+	switch os.Getenv("GS_HELPER") {
+	case "parent":
+		helperParent()
+	case "child":
+		helperChild()
+	default:
+		os.Exit(m.Run())
+	}
+}
+
+func helperParent() {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "GS_HELPER=child")
+	if err := cmd.Start(); err != nil {
+		os.Exit(1)
+	}
+
+	for i := 0; i < 500; i++ {
+		if out, _ := ioutil.ReadFile(os.Getenv("GS_OUT")); strings.Contains(string(out), "ready") {
+			os.Exit(0)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	os.Exit(1)
+}
+
+func helperChild() {
+	out := os.Getenv("GS_OUT")
+	done := make(chan int)
+
+	pdm := NewParentDeathManager(&ParentDeathManagerConfig{
+		PollInterval: time.Millisecond * 10,
+	})
+	pdm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		ioutil.WriteFile(out, []byte("ready\nshutdown\n"), 0644)
+		close(done)
+	}))
+
+	ioutil.WriteFile(out, []byte("ready\n"), 0644)
+
+	select {
+	case <-done:
+		os.Exit(0)
+	case <-time.After(10 * time.Second):
+		os.Exit(1)
+	}
+}
+
+func TestShutdownOnParentDeath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parentdeath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "GS_HELPER=parent", "GS_OUT="+out)
+	if err := cmd.Run(); err != nil {
+		t.Fatal("Parent helper failed:", err)
+	}
+
+	for i := 0; i < 500; i++ {
+		if contents, _ := ioutil.ReadFile(out); strings.Contains(string(contents), "shutdown") {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Error("Child did not start shutdown after parent died.")
+}
+
+func TestNoShutdownWhileParentAlive(t *testing.T) {
+	c := make(chan int, 100)
+
+	pdm := NewParentDeathManager(&ParentDeathManagerConfig{
+		PollInterval: time.Millisecond * 5,
+	})
+	pdm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	time.Sleep(time.Millisecond * 20)
+
+	if len(c) != 0 {
+		t.Error("Shutdown started while parent is alive.")
+	}
+}
+
+func TestPollDetectsReparenting(t *testing.T) {
+	c := make(chan int, 100)
+
+	pdm := NewParentDeathManager(&ParentDeathManagerConfig{
+		PollInterval: time.Millisecond * 5,
+	})
+	pdm.gs = GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	})
+	pdm.ppid = -1
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go pdm.poll(stop)
+
+	select {
+	case <-c:
+
+	case <-time.After(1 * time.Second):
+		t.Error("Timeout waiting for StartShutdown.")
+	}
+}
+
+func TestStop(t *testing.T) {
+	goroutines := testutil.Goroutines()
+
+	c := make(chan int, 100)
+	pdm := NewParentDeathManager(&ParentDeathManagerConfig{
+		PollInterval: time.Millisecond * 5,
+	})
+	pdm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	if err := pdm.Stop(); err != nil {
+		t.Error("Error in stop:", err)
+	}
+	pdm.Stop()
+	testutil.WaitGoroutines(t, goroutines)
+
+	if len(c) != 0 {
+		t.Error("Shutdown started while parent is alive.")
+	}
+
+	// Start after Stop watches the parent again, and its goroutines
+	// exit on the next Stop
+	if err := pdm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	})); err != nil {
+		t.Error("Error in restart:", err)
+	}
+	pdm.Stop()
+	testutil.WaitGoroutines(t, goroutines)
+}
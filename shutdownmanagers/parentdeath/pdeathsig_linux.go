@@ -0,0 +1,21 @@
+//go:build linux
+// +build linux
+
+package parentdeath
+
+import (
+	"syscall"
+)
+
+const (
+	deathSignalSupported = true
+	defaultDeathSignal   = syscall.SIGUSR2
+)
+
+func setDeathSignal(sig syscall.Signal) error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_PDEATHSIG, uintptr(sig), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
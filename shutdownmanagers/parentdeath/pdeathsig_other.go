@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package parentdeath
+
+import (
+	"syscall"
+)
+
+const (
+	deathSignalSupported = false
+	defaultDeathSignal   = syscall.Signal(0)
+)
+
+func setDeathSignal(sig syscall.Signal) error {
+	return nil
+}
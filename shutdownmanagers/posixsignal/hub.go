@@ -0,0 +1,228 @@
+package posixsignal
+
+import (
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+// hub is the single process-wide listener for posix signals. Every
+// PosixSignalManager registers with it on Start, so GracefulShutdown
+// instances embedded in libraries do not compete for the same signal.
+//
+// When a signal arrives, shutdown is started on every manager registered
+// for it, one after another, sorted by Order and then by registration
+// order. The last of them that has exit enabled, and whose
+// GracefulShutdown has no exit policy, is the only one that exits the
+// process in ShutdownFinish.
+//
+// A signal is listened to while any manager is registered for it or
+// has not yet reached its RestorePoint after being triggered.
+type hub struct {
+	mutex         sync.Mutex
+	seq           int
+	registrations []*registration
+	dispatching   []*PosixSignalManager
+	listeners     map[os.Signal]*listener
+	exitOwner     *PosixSignalManager
+}
+
+type registration struct {
+	manager *PosixSignalManager
+	gs      gracefulshutdown.GSInterface
+	seq     int
+}
+
+type listener struct {
+	c    chan os.Signal
+	quit chan struct{}
+}
+
+var defaultHub = &hub{
+	listeners: make(map[os.Signal]*listener),
+}
+
+// register adds the manager to the hub and starts listening for its
+// signals. Registering the same manager again replaces the previous
+// registration.
+func (h *hub) register(manager *PosixSignalManager, gs gracefulshutdown.GSInterface) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.remove(manager)
+
+	h.seq++
+	h.registrations = append(h.registrations, &registration{
+		manager: manager,
+		gs:      gs,
+		seq:     h.seq,
+	})
+
+	for _, sig := range manager.config.Signals {
+		if _, ok := h.listeners[sig]; ok {
+			continue
+		}
+		l := &listener{
+			c:    make(chan os.Signal, 1),
+			quit: make(chan struct{}),
+		}
+		h.listeners[sig] = l
+		signal.Notify(l.c, sig)
+		go h.listen(sig, l)
+	}
+}
+
+func (h *hub) listen(sig os.Signal, l *listener) {
+	for {
+		select {
+		case <-l.c:
+			h.dispatch(sig)
+		case <-l.quit:
+			return
+		}
+	}
+}
+
+// unregister removes the manager from the hub and stops listening for
+// signals no other manager needs.
+func (h *hub) unregister(manager *PosixSignalManager) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.remove(manager)
+	h.stopUnused()
+}
+
+// dispatch starts shutdown on all managers registered for sig. The
+// managers are unregistered, so each of them is triggered at most once.
+func (h *hub) dispatch(sig os.Signal) {
+	h.mutex.Lock()
+	var targets []*registration
+	for _, r := range h.registrations {
+		if r.manager.listensTo(sig) {
+			targets = append(targets, r)
+		}
+	}
+	for _, r := range targets {
+		h.remove(r.manager)
+		h.dispatching = append(h.dispatching, r.manager)
+	}
+	sort.SliceStable(targets, func(i, j int) bool {
+		if targets[i].manager.config.Order != targets[j].manager.config.Order {
+			return targets[i].manager.config.Order < targets[j].manager.config.Order
+		}
+		return targets[i].seq < targets[j].seq
+	})
+	for _, r := range targets {
+		if !r.manager.config.NoExit && !hasExitPolicy(r.gs) {
+			h.exitOwner = r.manager
+		}
+		if r.manager.config.RestorePoint == RestoreOnSignal {
+			h.releaseLocked(r.manager)
+		}
+	}
+	h.mutex.Unlock()
+
+	for _, r := range targets {
+		r.gs.StartShutdown(r.manager)
+	}
+
+	h.mutex.Lock()
+	for _, r := range targets {
+		h.releaseLocked(r.manager)
+	}
+	h.mutex.Unlock()
+}
+
+// release stops listening for signals of a triggered manager, unless
+// another manager still needs them.
+func (h *hub) release(manager *PosixSignalManager) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.releaseLocked(manager)
+}
+
+// releaseLocked is release for callers that hold the mutex.
+func (h *hub) releaseLocked(manager *PosixSignalManager) {
+	found := false
+	dispatching := h.dispatching[:0]
+	for _, m := range h.dispatching {
+		if m == manager {
+			found = true
+		} else {
+			dispatching = append(dispatching, m)
+		}
+	}
+	h.dispatching = dispatching
+	if !found {
+		return
+	}
+
+	h.stopUnused()
+
+	if manager.config.Reset {
+		for _, sig := range manager.config.Signals {
+			if _, ok := h.listeners[sig]; !ok {
+				signal.Reset(sig)
+			}
+		}
+	}
+}
+
+// remove removes all registrations of the manager. Caller must hold the mutex.
+func (h *hub) remove(manager *PosixSignalManager) {
+	registrations := h.registrations[:0]
+	for _, r := range h.registrations {
+		if r.manager != manager {
+			registrations = append(registrations, r)
+		}
+	}
+	h.registrations = registrations
+}
+
+// stopUnused stops listening for signals that no manager needs
+// anymore. Caller must hold the mutex.
+func (h *hub) stopUnused() {
+	for sig, l := range h.listeners {
+		if !h.used(sig) {
+			signal.Stop(l.c)
+			close(l.quit)
+			delete(h.listeners, sig)
+		}
+	}
+}
+
+// used returns true if a registered or triggered manager listens to
+// sig. Caller must hold the mutex.
+func (h *hub) used(sig os.Signal) bool {
+	for _, r := range h.registrations {
+		if r.manager.listensTo(sig) {
+			return true
+		}
+	}
+	for _, m := range h.dispatching {
+		if m.listensTo(sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasExitPolicy returns true if gs exits the process itself when
+// shutdown finishes, see GracefulShutdown.SetExitPolicy.
+func hasExitPolicy(gs gracefulshutdown.GSInterface) bool {
+	exitPolicier, ok := gs.(interface {
+		ExitPolicy() *gracefulshutdown.ExitPolicy
+	})
+	return ok && exitPolicier.ExitPolicy() != nil
+}
+
+// ownsExit returns true if the manager is the one that should exit the process.
+func (h *hub) ownsExit(manager *PosixSignalManager) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.exitOwner == manager
+}
@@ -1,13 +1,27 @@
 /*
 PosixSignalManager provides a listener for a posix signal. By default
 it listens for SIGINT and SIGTERM, but others can be chosen in NewPosixSignalManager.
-When ShutdownFinish is called it exits with os.Exit(0)
+When shutdown started by a signal finishes, it exits with os.Exit(0).
+Calling StartShutdown with a PosixSignalManager directly does not exit,
+and neither does a manager whose GracefulShutdown has an exit policy,
+see GracefulShutdown.SetExitPolicy.
+
+All PosixSignalManagers in a process share one signal listener, so
+several GracefulShutdown instances, for example ones embedded in
+libraries, can coexist. A signal starts shutdown on every manager
+listening to it, ordered by PosixSignalManagerConfig.Order, and only the
+last of them exits the process. Set NoExit on managers that should never
+exit the process.
+
+After a signal is caught, it is still handled by the manager until
+shutdown finishes, so repeated signals are ignored. Set RestorePoint to
+stop handling the signal earlier, so a second signal terminates the
+process with the default behaviour.
 */
 package posixsignal
 
 import (
 	"os"
-	"os/signal"
 	"syscall"
 
 	"github.com/Zemanta/gracefulshutdown"
@@ -15,23 +29,86 @@ import (
 
 const Name = "PosixSignalManager"
 
+// exit is replaced in tests.
+var exit = os.Exit
+
+// RestorePoint is the point in shutdown at which PosixSignalManager stops
+// handling its signals.
+type RestorePoint int
+
+const (
+	// RestoreAfterShutdown stops handling signals after shutdown finished
+	// on all managers the signal reached.
+	RestoreAfterShutdown RestorePoint = iota
+
+	// RestoreOnSignal stops handling signals as soon as a signal is caught,
+	// before shutdown starts.
+	RestoreOnSignal
+
+	// RestoreOnShutdownStart stops handling signals in ShutdownStart,
+	// before shutdown callbacks are run.
+	RestoreOnShutdownStart
+
+	// RestoreOnShutdownFinish stops handling signals in ShutdownFinish,
+	// after shutdown callbacks have finished.
+	RestoreOnShutdownFinish
+)
+
 // PosixSignalManager implements ShutdownManager interface that is added
 // to GracefulShutdown. Initialize with NewPosixSignalManager.
 type PosixSignalManager struct {
-	signals []os.Signal
+	config *PosixSignalManagerConfig
+}
+
+// PosixSignalManagerConfig provides configuration options for PosixSignalManager.
+type PosixSignalManagerConfig struct {
+	// Signals to listen to. Default is SIGINT and SIGTERM.
+	Signals []os.Signal
+
+	// Order of this manager when a signal starts shutdown on several
+	// managers. Managers with lower Order shut down first, managers with
+	// equal Order in the order they were started.
+	Order int
+
+	// NoExit disables exiting the process in ShutdownFinish.
+	NoExit bool
+
+	// RestorePoint is the point at which signals stop being handled.
+	// A signal is handled until all managers listening to it reach their
+	// RestorePoint. Default is RestoreAfterShutdown.
+	RestorePoint RestorePoint
+
+	// Reset restores the default behaviour of the signals with
+	// signal.Reset, even if other code in the process called
+	// signal.Notify for them. Without it the default behaviour is restored
+	// only if nothing else is notified of the signals.
+	Reset bool
+}
+
+func (psmc *PosixSignalManagerConfig) clean() {
+	if len(psmc.Signals) == 0 {
+		psmc.Signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
 }
 
 // NewPosixSignalManager initializes the PosixSignalManager.
 // As arguments you can provide os.Signal-s to listen to, if none are given,
 // it will default to SIGINT and SIGTERM.
 func NewPosixSignalManager(sig ...os.Signal) *PosixSignalManager {
-	if len(sig) == 0 {
-		sig = make([]os.Signal, 2)
-		sig[0] = os.Interrupt
-		sig[1] = syscall.SIGTERM
+	return NewPosixSignalManagerWithConfig(&PosixSignalManagerConfig{
+		Signals: sig,
+	})
+}
+
+// NewPosixSignalManagerWithConfig initializes the PosixSignalManager. See
+// PosixSignalManagerConfig for configuration options.
+func NewPosixSignalManagerWithConfig(posixSignalManagerConfig *PosixSignalManagerConfig) *PosixSignalManager {
+	if posixSignalManagerConfig == nil {
+		posixSignalManagerConfig = &PosixSignalManagerConfig{}
 	}
+	posixSignalManagerConfig.clean()
 	return &PosixSignalManager{
-		signals: sig,
+		config: posixSignalManagerConfig,
 	}
 }
 
@@ -42,26 +119,44 @@ func (posixSignalManager *PosixSignalManager) GetName() string {
 
 // Start starts listening for posix signals.
 func (posixSignalManager *PosixSignalManager) Start(gs gracefulshutdown.GSInterface) error {
-	go func() {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, posixSignalManager.signals...)
+	defaultHub.register(posixSignalManager, gs)
 
-		// Block until a signal is received.
-		<-c
+	return nil
+}
 
-		gs.StartShutdown(posixSignalManager)
-	}()
+func (posixSignalManager *PosixSignalManager) listensTo(sig os.Signal) bool {
+	for _, s := range posixSignalManager.config.Signals {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
 
+// Stop stops listening for posix signals.
+func (posixSignalManager *PosixSignalManager) Stop() error {
+	defaultHub.unregister(posixSignalManager)
 	return nil
 }
 
-// ShutdownStart does nothing.
+// ShutdownStart stops handling signals if RestorePoint is
+// RestoreOnShutdownStart.
 func (posixSignalManager *PosixSignalManager) ShutdownStart() error {
+	if posixSignalManager.config.RestorePoint == RestoreOnShutdownStart {
+		defaultHub.release(posixSignalManager)
+	}
 	return nil
 }
 
-// ShutdownFinish exits the app with os.Exit(0)
+// ShutdownFinish stops handling signals if RestorePoint is
+// RestoreOnShutdownFinish and exits the app with os.Exit(0) if a signal
+// started shutdown and this manager owns the exit.
 func (posixSignalManager *PosixSignalManager) ShutdownFinish() error {
-	os.Exit(0)
+	if posixSignalManager.config.RestorePoint == RestoreOnShutdownFinish {
+		defaultHub.release(posixSignalManager)
+	}
+	if defaultHub.ownsExit(posixSignalManager) {
+		exit(0)
+	}
 	return nil
 }
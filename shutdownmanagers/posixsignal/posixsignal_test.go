@@ -1,11 +1,14 @@
 package posixsignal
 
 import (
+	"os"
+	"os/signal"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/internal/testutil"
 )
 
 type startShutdownFunc func(sm gracefulshutdown.ShutdownManager)
@@ -70,3 +73,209 @@ func TestStartShutdownCalledCustomSignal(t *testing.T) {
 
 	waitSig(t, c)
 }
+
+func TestFanOutInOrderWithOneExit(t *testing.T) {
+	exited := false
+	exit = func(int) {
+		exited = true
+	}
+	defer func() {
+		exit = os.Exit
+	}()
+
+	c := make(chan *PosixSignalManager, 100)
+	exits := make(chan *PosixSignalManager, 100)
+	gs := startShutdownFunc(func(sm gracefulshutdown.ShutdownManager) {
+		psm := sm.(*PosixSignalManager)
+		exited = false
+		sm.ShutdownFinish()
+		if exited {
+			exits <- psm
+		}
+		c <- psm
+	})
+
+	app := NewPosixSignalManagerWithConfig(&PosixSignalManagerConfig{
+		Signals: []os.Signal{syscall.SIGUSR1},
+		Order:   1,
+	})
+	library := NewPosixSignalManagerWithConfig(&PosixSignalManagerConfig{
+		Signals: []os.Signal{syscall.SIGUSR1},
+	})
+	other := NewPosixSignalManagerWithConfig(&PosixSignalManagerConfig{
+		Signals: []os.Signal{syscall.SIGUSR1},
+		Order:   2,
+		NoExit:  true,
+	})
+	app.Start(gs)
+	library.Start(gs)
+	other.Start(gs)
+
+	time.Sleep(time.Millisecond)
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+
+	for i, expected := range []*PosixSignalManager{library, app, other} {
+		select {
+		case psm := <-c:
+			if psm != expected {
+				t.Error("Unexpected manager at position", i)
+			}
+
+		case <-time.After(1 * time.Second):
+			t.Fatal("Timeout waiting for StartShutdown.")
+		}
+	}
+
+	if len(exits) != 1 {
+		t.Fatal("Expected 1 exit, got", len(exits))
+	}
+	if <-exits != app {
+		t.Error("Expected app manager to exit.")
+	}
+}
+
+func TestNoExitWithoutSignal(t *testing.T) {
+	exited := make(chan int, 100)
+	exit = func(code int) {
+		exited <- code
+	}
+	defer func() {
+		exit = os.Exit
+	}()
+
+	psm := NewPosixSignalManager()
+	psm.ShutdownFinish()
+
+	if len(exited) != 0 {
+		t.Error("Expected no exit when shutdown was not started by a signal.")
+	}
+}
+
+type exitPolicyGs struct {
+	startShutdownFunc
+}
+
+func (gs exitPolicyGs) ExitPolicy() *gracefulshutdown.ExitPolicy {
+	return &gracefulshutdown.ExitPolicy{}
+}
+
+func TestNoExitWithExitPolicy(t *testing.T) {
+	exited := make(chan int, 100)
+	exit = func(code int) {
+		exited <- code
+	}
+	defer func() {
+		exit = os.Exit
+	}()
+
+	c := make(chan int, 100)
+	psm := NewPosixSignalManager(syscall.SIGUSR2)
+	psm.Start(exitPolicyGs{startShutdownFunc(func(sm gracefulshutdown.ShutdownManager) {
+		sm.ShutdownFinish()
+		c <- 1
+	})})
+
+	time.Sleep(time.Millisecond)
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
+
+	waitSig(t, c)
+	if len(exited) != 0 {
+		t.Error("Expected exit to be left to the exit policy.")
+	}
+}
+
+func TestRestoreOnShutdownStart(t *testing.T) {
+	c := make(chan bool, 100)
+	gs := startShutdownFunc(func(sm gracefulshutdown.ShutdownManager) {
+		sm.ShutdownStart()
+		defaultHub.mutex.Lock()
+		_, ok := defaultHub.listeners[syscall.SIGUSR2]
+		defaultHub.mutex.Unlock()
+		c <- ok
+	})
+
+	psm := NewPosixSignalManagerWithConfig(&PosixSignalManagerConfig{
+		Signals:      []os.Signal{syscall.SIGUSR2},
+		RestorePoint: RestoreOnShutdownStart,
+	})
+	psm.Start(gs)
+
+	time.Sleep(time.Millisecond)
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
+
+	select {
+	case listening := <-c:
+		if listening {
+			t.Error("Expected SIGUSR2 not to be handled after ShutdownStart.")
+		}
+
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for StartShutdown.")
+	}
+}
+
+func TestResetOnSignal(t *testing.T) {
+	other := make(chan os.Signal, 100)
+	signal.Notify(other, syscall.SIGWINCH)
+	defer signal.Stop(other)
+
+	c := make(chan int, 100)
+	psm := NewPosixSignalManagerWithConfig(&PosixSignalManagerConfig{
+		Signals:      []os.Signal{syscall.SIGWINCH},
+		RestorePoint: RestoreOnSignal,
+		Reset:        true,
+	})
+	psm.Start(startShutdownFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	time.Sleep(time.Millisecond)
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGWINCH)
+
+	waitSig(t, c)
+	<-other
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGWINCH)
+
+	time.Sleep(time.Millisecond * 10)
+
+	if len(other) != 0 {
+		t.Error("Expected SIGWINCH to be reset for all channels.")
+	}
+}
+
+func TestStop(t *testing.T) {
+	goroutines := testutil.Goroutines()
+
+	psm := NewPosixSignalManager(syscall.SIGUSR2)
+	psm.Start(startShutdownFunc(func(sm gracefulshutdown.ShutdownManager) {
+		t.Error("Expected no shutdown after Stop.")
+	}))
+
+	psm.Stop()
+
+	defaultHub.mutex.Lock()
+	_, ok := defaultHub.listeners[syscall.SIGUSR2]
+	defaultHub.mutex.Unlock()
+	if ok {
+		t.Error("Expected SIGUSR2 not to be handled after Stop.")
+	}
+
+	testutil.WaitGoroutines(t, goroutines)
+
+	// Start after Stop handles the signal again
+	c := make(chan int, 100)
+	psm.Start(startShutdownFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	time.Sleep(time.Millisecond)
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
+
+	waitSig(t, c)
+}
@@ -0,0 +1,48 @@
+//go:build linux
+// +build linux
+
+package resourcemanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// RSS returns resident memory of the process from /proc/self/statm.
+func (processSampler) RSS() (uint64, error) {
+	statm, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("resourcemanager: invalid /proc/self/statm: %q", statm)
+	}
+
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * uint64(os.Getpagesize()), nil
+}
+
+// FDs returns number of open file descriptors from /proc/self/fd.
+func (processSampler) FDs() (uint64, error) {
+	dir, err := os.Open("/proc/self/fd")
+	if err != nil {
+		return 0, err
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return 0, err
+	}
+
+	// do not count the descriptor of the opened directory
+	return uint64(len(names) - 1), nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package resourcemanager
+
+func (processSampler) RSS() (uint64, error) {
+	return 0, ErrUnsupported
+}
+
+func (processSampler) FDs() (uint64, error) {
+	return 0, ErrUnsupported
+}
@@ -0,0 +1,271 @@
+/*
+ResourceManager samples resident memory, number of open file descriptors
+and number of goroutines of the process and starts shutdown when any of
+them stays above its limit for the configured duration. This lets slowly
+leaking workers be recycled gracefully instead of being killed.
+
+Resident memory and open file descriptors are read from /proc and are
+only available on Linux.
+
+ResourceManager does not exit the process. Use
+GracefulShutdown.SetExitPolicy, so the process releases the leaked
+resources and can be restarted once shutdown finishes.
+*/
+package resourcemanager
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+const (
+	Name = "ResourceManager"
+
+	ResourceRSS        = "rss"
+	ResourceFDs        = "fds"
+	ResourceGoroutines = "goroutines"
+
+	defaultSampleInterval = time.Second * 10
+)
+
+// ErrUnsupported is returned from Start if a limit is set for
+// a resource that can not be sampled on this platform.
+var ErrUnsupported = errors.New("resourcemanager: resource not supported on this platform")
+
+// ResourceManager implements ShutdownManager interface that is added
+// to GracefulShutdown. Initialize with NewResourceManager.
+type ResourceManager struct {
+	gs      gracefulshutdown.GSInterface
+	config  *ResourceManagerConfig
+	sampler sampler
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mutex    sync.Mutex
+	exceeded *Exceeded
+}
+
+// ResourceManagerConfig provides configuration options for ResourceManager.
+// Limits that are 0 are not checked.
+type ResourceManagerConfig struct {
+	// MaxRSS is limit for resident memory in bytes.
+	MaxRSS uint64
+
+	// MaxFDs is limit for number of open file descriptors.
+	MaxFDs uint64
+
+	// MaxGoroutines is limit for number of goroutines.
+	MaxGoroutines uint64
+
+	// Duration is how long a resource has to stay above its limit
+	// before shutdown is started. Default is 0, which starts shutdown
+	// on first sample above limit.
+	Duration time.Duration
+
+	// SampleInterval is period for sampling resources.
+	// Default is 10 seconds.
+	SampleInterval time.Duration
+}
+
+// Exceeded describes the resource that started shutdown.
+type Exceeded struct {
+	Resource string
+	Limit    uint64
+	Value    uint64
+	Since    time.Time
+}
+
+func (e *Exceeded) String() string {
+	return fmt.Sprintf("%s %d exceeded limit %d since %s", e.Resource, e.Value, e.Limit, e.Since.Format(time.RFC3339))
+}
+
+type sampler interface {
+	RSS() (uint64, error)
+	FDs() (uint64, error)
+	Goroutines() uint64
+}
+
+type processSampler struct{}
+
+func (processSampler) Goroutines() uint64 {
+	return uint64(runtime.NumGoroutine())
+}
+
+func (rmc *ResourceManagerConfig) clean() {
+	if rmc.SampleInterval == 0 {
+		rmc.SampleInterval = defaultSampleInterval
+	}
+}
+
+// NewResourceManager initializes the ResourceManager. See
+// ResourceManagerConfig for configuration options.
+func NewResourceManager(resourceManagerConfig *ResourceManagerConfig) *ResourceManager {
+	if resourceManagerConfig == nil {
+		resourceManagerConfig = &ResourceManagerConfig{}
+	}
+	resourceManagerConfig.clean()
+	return &ResourceManager{
+		config:  resourceManagerConfig,
+		sampler: processSampler{},
+	}
+}
+
+// GetName returns name of this ShutdownManager.
+func (resourceManager *ResourceManager) GetName() string {
+	return Name
+}
+
+// Start starts sampling resources. Will return ErrUnsupported if a limit
+// is set for a resource that can not be sampled.
+func (resourceManager *ResourceManager) Start(gs gracefulshutdown.GSInterface) error {
+	resourceManager.gs = gs
+
+	if resourceManager.config.MaxRSS > 0 {
+		if _, err := resourceManager.sampler.RSS(); err != nil {
+			return err
+		}
+	}
+
+	if resourceManager.config.MaxFDs > 0 {
+		if _, err := resourceManager.sampler.FDs(); err != nil {
+			return err
+		}
+	}
+
+	stop := make(chan struct{})
+	resourceManager.stop = stop
+	resourceManager.stopOnce = sync.Once{}
+	go resourceManager.sample(stop)
+
+	return nil
+}
+
+// Exceeded returns the resource that started shutdown, or nil.
+func (resourceManager *ResourceManager) Exceeded() *Exceeded {
+	resourceManager.mutex.Lock()
+	defer resourceManager.mutex.Unlock()
+	return resourceManager.exceeded
+}
+
+// Reason returns description of the resource that started shutdown.
+func (resourceManager *ResourceManager) Reason() string {
+	if exceeded := resourceManager.Exceeded(); exceeded != nil {
+		return exceeded.String()
+	}
+	return ""
+}
+
+func (resourceManager *ResourceManager) sample(stop <-chan struct{}) {
+	ticker := time.NewTicker(resourceManager.config.SampleInterval)
+	defer ticker.Stop()
+
+	// since is kept per run, so a run left over from before Stop does
+	// not share it with the next Start
+	since := make(map[string]time.Time)
+	var now time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case now = <-ticker.C:
+		}
+
+		exceeded := resourceManager.observe(since, now)
+		if exceeded == nil {
+			continue
+		}
+
+		resourceManager.mutex.Lock()
+		resourceManager.exceeded = exceeded
+		resourceManager.mutex.Unlock()
+
+		resourceManager.gs.StartShutdown(resourceManager)
+		return
+	}
+}
+
+// observe samples resources at time now and returns the resource that
+// has been above its limit for Duration, or nil. since holds the time
+// each resource went above its limit.
+func (resourceManager *ResourceManager) observe(since map[string]time.Time, now time.Time) *Exceeded {
+	over := make(map[string]bool)
+	var result *Exceeded
+	for _, exceeded := range resourceManager.check() {
+		over[exceeded.Resource] = true
+
+		start, ok := since[exceeded.Resource]
+		if !ok {
+			start = now
+			since[exceeded.Resource] = now
+		}
+		if result == nil && now.Sub(start) >= resourceManager.config.Duration {
+			exceeded.Since = start
+			result = exceeded
+		}
+	}
+
+	// resources back below limit start counting again
+	for resource := range since {
+		if !over[resource] {
+			delete(since, resource)
+		}
+	}
+
+	return result
+}
+
+// check returns resources that are above their limits.
+func (resourceManager *ResourceManager) check() []*Exceeded {
+	var exceeded []*Exceeded
+	add := func(resource string, limit, value uint64) {
+		if limit > 0 && value > limit {
+			exceeded = append(exceeded, &Exceeded{
+				Resource: resource,
+				Limit:    limit,
+				Value:    value,
+			})
+		}
+	}
+
+	if resourceManager.config.MaxRSS > 0 {
+		rss, err := resourceManager.sampler.RSS()
+		resourceManager.gs.ReportError(gracefulshutdown.NewError(Name, "sample", gracefulshutdown.SeverityWarning, err))
+		add(ResourceRSS, resourceManager.config.MaxRSS, rss)
+	}
+
+	if resourceManager.config.MaxFDs > 0 {
+		fds, err := resourceManager.sampler.FDs()
+		resourceManager.gs.ReportError(gracefulshutdown.NewError(Name, "sample", gracefulshutdown.SeverityWarning, err))
+		add(ResourceFDs, resourceManager.config.MaxFDs, fds)
+	}
+
+	add(ResourceGoroutines, resourceManager.config.MaxGoroutines, resourceManager.sampler.Goroutines())
+
+	return exceeded
+}
+
+// Stop stops sampling resource usage.
+func (resourceManager *ResourceManager) Stop() error {
+	resourceManager.stopOnce.Do(func() {
+		if resourceManager.stop != nil {
+			close(resourceManager.stop)
+		}
+	})
+	return nil
+}
+
+// ShutdownStart does nothing.
+func (resourceManager *ResourceManager) ShutdownStart() error {
+	return nil
+}
+
+// ShutdownFinish does nothing.
+func (resourceManager *ResourceManager) ShutdownFinish() error {
+	return nil
+}
@@ -0,0 +1,204 @@
+package resourcemanager
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/internal/testutil"
+)
+
+type GSFunc func(sm gracefulshutdown.ShutdownManager)
+
+func (f GSFunc) StartShutdown(sm gracefulshutdown.ShutdownManager) {
+	f(sm)
+}
+
+func (f GSFunc) ReportError(err error) {
+
+}
+
+func (f GSFunc) AddShutdownCallback(shutdownCallback gracefulshutdown.ShutdownCallback) {
+
+}
+
+type samplerMock struct {
+	mutex      sync.Mutex
+	rss        uint64
+	fds        uint64
+	goroutines uint64
+}
+
+func (s *samplerMock) set(rss, fds, goroutines uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rss, s.fds, s.goroutines = rss, fds, goroutines
+}
+
+func (s *samplerMock) RSS() (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.rss, nil
+}
+
+func (s *samplerMock) FDs() (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.fds, nil
+}
+
+func (s *samplerMock) Goroutines() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.goroutines
+}
+
+func waitShutdown(t *testing.T, c <-chan int) {
+	select {
+	case <-c:
+
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for StartShutdown.")
+	}
+}
+
+func TestRSSExceeded(t *testing.T) {
+	c := make(chan int, 100)
+	mock := &samplerMock{}
+	mock.set(200, 10, 10)
+
+	rm := NewResourceManager(&ResourceManagerConfig{
+		MaxRSS:         100,
+		MaxFDs:         100,
+		SampleInterval: time.Millisecond,
+	})
+	rm.sampler = mock
+	rm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	waitShutdown(t, c)
+
+	exceeded := rm.Exceeded()
+	if exceeded == nil || exceeded.Resource != ResourceRSS || exceeded.Limit != 100 || exceeded.Value != 200 {
+		t.Error("Expected rss to exceed limit, got", exceeded)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	mock := &samplerMock{}
+	rm := NewResourceManager(&ResourceManagerConfig{
+		MaxFDs:   100,
+		Duration: time.Second * 30,
+	})
+	rm.sampler = mock
+	rm.gs = GSFunc(func(sm gracefulshutdown.ShutdownManager) {})
+
+	since := make(map[string]time.Time)
+	start := time.Now()
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+
+	// spikes shorter than duration do not start shutdown
+	for i := 0; i < 3; i++ {
+		mock.set(0, 200, 0)
+		if exceeded := rm.observe(since, at(i*20)); exceeded != nil {
+			t.Fatal("Shutdown started on spike:", exceeded)
+		}
+		mock.set(0, 50, 0)
+		if exceeded := rm.observe(since, at(i*20+10)); exceeded != nil {
+			t.Fatal("Shutdown started below limit:", exceeded)
+		}
+	}
+
+	mock.set(0, 200, 0)
+	if exceeded := rm.observe(since, at(100)); exceeded != nil {
+		t.Fatal("Shutdown started before duration passed:", exceeded)
+	}
+	if exceeded := rm.observe(since, at(120)); exceeded != nil {
+		t.Fatal("Shutdown started before duration passed:", exceeded)
+	}
+
+	exceeded := rm.observe(since, at(130))
+	if exceeded == nil || exceeded.Resource != ResourceFDs || !exceeded.Since.Equal(at(100)) {
+		t.Error("Expected fds to exceed limit since 100s, got", exceeded)
+	}
+}
+
+func TestGoroutines(t *testing.T) {
+	c := make(chan int, 100)
+	rm := NewResourceManager(&ResourceManagerConfig{
+		MaxGoroutines:  uint64(runtime.NumGoroutine() + 10),
+		SampleInterval: time.Millisecond,
+	})
+	rm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	done := make(chan int)
+	defer close(done)
+	for i := 0; i < 20; i++ {
+		go func() {
+			<-done
+		}()
+	}
+
+	waitShutdown(t, c)
+
+	if rm.Exceeded().Resource != ResourceGoroutines {
+		t.Error("Expected goroutines to exceed limit, got", rm.Exceeded())
+	}
+}
+
+func TestProcessSampler(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Only supported on linux.")
+	}
+
+	rss, err := processSampler{}.RSS()
+	if err != nil || rss == 0 {
+		t.Error("Expected rss, got", rss, err)
+	}
+
+	fds, err := processSampler{}.FDs()
+	if err != nil || fds < 3 {
+		t.Error("Expected at least 3 fds, got", fds, err)
+	}
+}
+
+func TestStop(t *testing.T) {
+	c := make(chan int, 100)
+	mock := &samplerMock{}
+	mock.set(50, 10, 10)
+
+	goroutines := testutil.Goroutines()
+	rm := NewResourceManager(&ResourceManagerConfig{
+		MaxRSS:         100,
+		SampleInterval: time.Millisecond,
+	})
+	rm.sampler = mock
+	rm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+
+	if err := rm.Stop(); err != nil {
+		t.Error("Error in stop:", err)
+	}
+	rm.Stop()
+	testutil.WaitGoroutines(t, goroutines)
+
+	mock.set(200, 10, 10)
+	time.Sleep(time.Millisecond * 10)
+	if len(c) != 0 {
+		t.Error("Shutdown started after Stop.")
+	}
+
+	rm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		c <- 1
+	}))
+	defer rm.Stop()
+	waitShutdown(t, c)
+}
@@ -0,0 +1,71 @@
+package schedulemanager
+
+import (
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04 Mon", s, time.UTC)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Error("Expected error for schedule", spec)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	for _, test := range []struct {
+		spec string
+		from string
+		next string
+	}{
+		{"* * * * *", "2026-10-18 10:15 Sun", "2026-10-18 10:16 Sun"},
+		{"30 3 * * *", "2026-10-18 10:15 Sun", "2026-10-19 03:30 Mon"},
+		{"30 3 * * *", "2026-10-18 03:29 Sun", "2026-10-18 03:30 Sun"},
+		{"*/15 * * * *", "2026-10-18 10:15 Sun", "2026-10-18 10:30 Sun"},
+		{"0 2-4 * * *", "2026-10-18 04:00 Sun", "2026-10-19 02:00 Mon"},
+		{"0 0 1 * *", "2026-10-18 10:15 Sun", "2026-11-01 00:00 Sun"},
+		{"0 0 * * 1-5", "2026-10-17 10:15 Sat", "2026-10-19 00:00 Mon"},
+		{"0 0 * * 7", "2026-10-19 10:15 Mon", "2026-10-25 00:00 Sun"},
+		{"0 0 29 2 *", "2026-10-18 10:15 Sun", "2028-02-29 00:00 Tue"},
+		{"0 0 13 * 5", "2026-10-18 10:15 Sun", "2026-10-23 00:00 Fri"},
+		{"0,30 1 * * *", "2026-10-18 01:00 Sun", "2026-10-18 01:30 Sun"},
+	} {
+		schedule, err := ParseSchedule(test.spec)
+		if err != nil {
+			t.Error("Error parsing", test.spec, err)
+			continue
+		}
+
+		if next := schedule.Next(date(test.from)); !next.Equal(date(test.next)) {
+			t.Errorf("Schedule %q from %s: expected %s, got %s", test.spec, test.from, test.next, next)
+		}
+	}
+}
+
+func TestScheduleNextNever(t *testing.T) {
+	schedule, _ := ParseSchedule("0 0 31 2 *")
+
+	if next := schedule.Next(date("2026-10-18 10:15 Sun")); !next.IsZero() {
+		t.Error("Expected no next time, got", next)
+	}
+}
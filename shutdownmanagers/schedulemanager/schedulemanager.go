@@ -0,0 +1,234 @@
+/*
+ScheduleManager starts shutdown at a scheduled time, for planned recycles
+without outside orchestration. The time is given with a cron schedule,
+a list of maintenance windows, or both, in which case the earliest time
+is used.
+
+If Warning is set, warning callbacks are called Warning before shutdown
+starts, so the application can get ready, for example stop accepting
+long running jobs.
+
+ScheduleManager does not exit the process. Use
+GracefulShutdown.SetExitPolicy, so its supervisor restarts it once
+shutdown finishes.
+*/
+package schedulemanager
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+const Name = "ScheduleManager"
+
+// ErrNoSchedule is returned from Start if there is no future time
+// in Schedule or Windows.
+var ErrNoSchedule = errors.New("schedulemanager: no scheduled time")
+
+// WarningCallback is an interface you have to implement for warning
+// callbacks. OnWarning is called Warning before shutdown starts, with
+// the time shutdown will start.
+type WarningCallback interface {
+	OnWarning(shutdownAt time.Time)
+}
+
+// WarningFunc is a helper type, so you can easily provide anonymous functions
+// as WarningCallbacks.
+type WarningFunc func(shutdownAt time.Time)
+
+func (f WarningFunc) OnWarning(shutdownAt time.Time) {
+	f(shutdownAt)
+}
+
+// ScheduleManager implements ShutdownManager interface that is added
+// to GracefulShutdown. Initialize with NewScheduleManager.
+type ScheduleManager struct {
+	gs     gracefulshutdown.GSInterface
+	config *ScheduleManagerConfig
+	clock  clock
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mutex            sync.Mutex
+	warningCallbacks []WarningCallback
+	next             time.Time
+}
+
+// ScheduleManagerConfig provides configuration options for ScheduleManager.
+type ScheduleManagerConfig struct {
+	// Schedule is a cron schedule, see ParseSchedule. Times are in
+	// local time zone.
+	Schedule string
+
+	// Windows are maintenance windows. Shutdown starts at the start of
+	// the first window that has not ended yet, or immediately if it
+	// has already started.
+	Windows []Window
+
+	// Warning is time between warning callbacks and start of shutdown.
+	Warning time.Duration
+}
+
+// Window is a maintenance window.
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// NewScheduleManager initializes the ScheduleManager. See
+// ScheduleManagerConfig for configuration options.
+func NewScheduleManager(scheduleManagerConfig *ScheduleManagerConfig) *ScheduleManager {
+	if scheduleManagerConfig == nil {
+		scheduleManagerConfig = &ScheduleManagerConfig{}
+	}
+	return &ScheduleManager{
+		config: scheduleManagerConfig,
+		clock:  realClock{},
+	}
+}
+
+// GetName returns name of this ShutdownManager.
+func (scheduleManager *ScheduleManager) GetName() string {
+	return Name
+}
+
+// AddWarningCallback adds a WarningCallback that will be called Warning
+// before scheduled shutdown.
+func (scheduleManager *ScheduleManager) AddWarningCallback(warningCallback WarningCallback) {
+	scheduleManager.mutex.Lock()
+	defer scheduleManager.mutex.Unlock()
+	scheduleManager.warningCallbacks = append(scheduleManager.warningCallbacks, warningCallback)
+}
+
+// Next returns time when shutdown will start. It is zero before Start.
+func (scheduleManager *ScheduleManager) Next() time.Time {
+	scheduleManager.mutex.Lock()
+	defer scheduleManager.mutex.Unlock()
+	return scheduleManager.next
+}
+
+// Start starts waiting for scheduled time. Will return error if Schedule
+// is invalid or if there is no future scheduled time.
+func (scheduleManager *ScheduleManager) Start(gs gracefulshutdown.GSInterface) error {
+	scheduleManager.gs = gs
+
+	next, err := scheduleManager.nextTime(scheduleManager.clock.Now())
+	if err != nil {
+		return err
+	}
+
+	scheduleManager.mutex.Lock()
+	scheduleManager.next = next
+	scheduleManager.mutex.Unlock()
+
+	stop := make(chan struct{})
+	scheduleManager.stop = stop
+	scheduleManager.stopOnce = sync.Once{}
+	go scheduleManager.wait(stop, next)
+
+	return nil
+}
+
+// nextTime returns earliest time from Schedule and Windows after now.
+func (scheduleManager *ScheduleManager) nextTime(now time.Time) (time.Time, error) {
+	var next time.Time
+
+	if scheduleManager.config.Schedule != "" {
+		schedule, err := ParseSchedule(scheduleManager.config.Schedule)
+		if err != nil {
+			return next, err
+		}
+		next = schedule.Next(now)
+	}
+
+	for _, window := range scheduleManager.config.Windows {
+		if !window.End.IsZero() && !window.End.After(now) {
+			continue
+		}
+
+		start := window.Start
+		if start.Before(now) {
+			start = now
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+
+	if next.IsZero() {
+		return next, ErrNoSchedule
+	}
+	return next, nil
+}
+
+func (scheduleManager *ScheduleManager) wait(stop <-chan struct{}, next time.Time) {
+	if scheduleManager.config.Warning > 0 {
+		if !scheduleManager.sleepUntil(stop, next.Add(-scheduleManager.config.Warning)) {
+			return
+		}
+
+		scheduleManager.mutex.Lock()
+		warningCallbacks := scheduleManager.warningCallbacks
+		scheduleManager.mutex.Unlock()
+
+		for _, warningCallback := range warningCallbacks {
+			warningCallback.OnWarning(next)
+		}
+	}
+
+	if !scheduleManager.sleepUntil(stop, next) {
+		return
+	}
+	scheduleManager.gs.StartShutdown(scheduleManager)
+}
+
+// sleepUntil returns false if ScheduleManager was stopped.
+func (scheduleManager *ScheduleManager) sleepUntil(stop <-chan struct{}, t time.Time) bool {
+	if d := t.Sub(scheduleManager.clock.Now()); d > 0 {
+		select {
+		case <-scheduleManager.clock.After(d):
+		case <-stop:
+			return false
+		}
+	}
+	return true
+}
+
+// Stop stops waiting for scheduled time.
+func (scheduleManager *ScheduleManager) Stop() error {
+	scheduleManager.stopOnce.Do(func() {
+		if scheduleManager.stop != nil {
+			close(scheduleManager.stop)
+		}
+	})
+	return nil
+}
+
+// ShutdownStart does nothing.
+func (scheduleManager *ScheduleManager) ShutdownStart() error {
+	return nil
+}
+
+// ShutdownFinish does nothing.
+func (scheduleManager *ScheduleManager) ShutdownFinish() error {
+	return nil
+}
@@ -0,0 +1,208 @@
+package schedulemanager
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/internal/testutil"
+)
+
+type GSFunc func(sm gracefulshutdown.ShutdownManager)
+
+func (f GSFunc) StartShutdown(sm gracefulshutdown.ShutdownManager) {
+	f(sm)
+}
+
+func (f GSFunc) ReportError(err error) {
+
+}
+
+func (f GSFunc) AddShutdownCallback(shutdownCallback gracefulshutdown.ShutdownCallback) {
+
+}
+
+// fakeClock advances time immediately on every After call and
+// records requested durations.
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = c.now.Add(d)
+	c.waits = append(c.waits, d)
+
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func waitEvent(t *testing.T, c <-chan string) string {
+	select {
+	case event := <-c:
+		return event
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for event.")
+	}
+	return ""
+}
+
+func TestInvalidSchedule(t *testing.T) {
+	sm := NewScheduleManager(&ScheduleManagerConfig{Schedule: "invalid"})
+	sm.clock = &fakeClock{now: date("2026-10-18 10:15 Sun")}
+
+	if err := sm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {})); err == nil {
+		t.Error("Expected error for invalid schedule.")
+	}
+}
+
+func TestNoSchedule(t *testing.T) {
+	sm := NewScheduleManager(&ScheduleManagerConfig{
+		Windows: []Window{{
+			Start: date("2026-10-17 01:00 Sat"),
+			End:   date("2026-10-17 03:00 Sat"),
+		}},
+	})
+	sm.clock = &fakeClock{now: date("2026-10-18 10:15 Sun")}
+
+	if err := sm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {})); err != ErrNoSchedule {
+		t.Error("Expected ErrNoSchedule, got", err)
+	}
+}
+
+func TestScheduleWithWarning(t *testing.T) {
+	events := make(chan string, 100)
+	clock := &fakeClock{now: date("2026-10-18 10:15 Sun")}
+
+	sm := NewScheduleManager(&ScheduleManagerConfig{
+		Schedule: "0 3 * * *",
+		Warning:  time.Minute * 10,
+	})
+	sm.clock = clock
+	sm.AddWarningCallback(WarningFunc(func(shutdownAt time.Time) {
+		if shutdownAt.Equal(date("2026-10-19 03:00 Mon")) && clock.Now().Equal(date("2026-10-19 02:50 Mon")) {
+			events <- "warning"
+		} else {
+			events <- "wrong warning"
+		}
+	}))
+	sm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		events <- "shutdown"
+	}))
+
+	if !sm.Next().Equal(date("2026-10-19 03:00 Mon")) {
+		t.Error("Unexpected next shutdown", sm.Next())
+	}
+
+	if event := waitEvent(t, events); event != "warning" {
+		t.Error("Expected warning, got", event)
+	}
+
+	if event := waitEvent(t, events); event != "shutdown" {
+		t.Error("Expected shutdown, got", event)
+	}
+
+	if !clock.Now().Equal(date("2026-10-19 03:00 Mon")) {
+		t.Error("Shutdown started at wrong time", clock.Now())
+	}
+
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	if len(clock.waits) != 2 || clock.waits[0] != time.Hour*16+time.Minute*35 || clock.waits[1] != time.Minute*10 {
+		t.Error("Unexpected waits", clock.waits)
+	}
+}
+
+func TestEarliestWindow(t *testing.T) {
+	sm := NewScheduleManager(&ScheduleManagerConfig{
+		Schedule: "0 3 * * *",
+		Windows: []Window{
+			{Start: date("2026-10-17 01:00 Sat"), End: date("2026-10-17 03:00 Sat")},
+			{Start: date("2026-10-18 22:00 Sun"), End: date("2026-10-18 23:00 Sun")},
+		},
+	})
+	sm.clock = &fakeClock{now: date("2026-10-18 10:15 Sun")}
+	sm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {}))
+
+	if !sm.Next().Equal(date("2026-10-18 22:00 Sun")) {
+		t.Error("Expected window start, got", sm.Next())
+	}
+}
+
+func TestInsideWindow(t *testing.T) {
+	events := make(chan string, 100)
+	now := date("2026-10-18 10:15 Sun")
+
+	sm := NewScheduleManager(&ScheduleManagerConfig{
+		Windows: []Window{{
+			Start: date("2026-10-18 10:00 Sun"),
+			End:   date("2026-10-18 11:00 Sun"),
+		}},
+	})
+	sm.clock = &fakeClock{now: now}
+	sm.Start(GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		events <- "shutdown"
+	}))
+
+	if !sm.Next().Equal(now) {
+		t.Error("Expected immediate shutdown, got", sm.Next())
+	}
+
+	waitEvent(t, events)
+}
+
+func TestStop(t *testing.T) {
+	goroutines := testutil.Goroutines()
+
+	events := make(chan string, 100)
+	sm := NewScheduleManager(&ScheduleManagerConfig{
+		Schedule: "0 3 * * *",
+		Warning:  time.Minute * 10,
+	})
+	sm.AddWarningCallback(WarningFunc(func(shutdownAt time.Time) {
+		events <- "warning"
+	}))
+	gs := GSFunc(func(sm gracefulshutdown.ShutdownManager) {
+		events <- "shutdown"
+	})
+	if err := sm.Start(gs); err != nil {
+		t.Fatal("Error in start:", err)
+	}
+
+	if err := sm.Stop(); err != nil {
+		t.Error("Error in stop:", err)
+	}
+	sm.Stop()
+	testutil.WaitGoroutines(t, goroutines)
+
+	if len(events) != 0 {
+		t.Error("Expected no events after Stop, got", <-events)
+	}
+
+	// Start after Stop waits for the next scheduled time again, until
+	// the next Stop
+	if err := sm.Start(gs); err != nil {
+		t.Fatal("Error in restart:", err)
+	}
+	if next := sm.Next(); next.Hour() != 3 || !next.After(time.Now()) {
+		t.Error("Expected next shutdown at 3:00, got", next)
+	}
+	sm.Stop()
+	testutil.WaitGoroutines(t, goroutines)
+
+	if len(events) != 0 {
+		t.Error("Expected no events after restart and Stop, got", <-events)
+	}
+}
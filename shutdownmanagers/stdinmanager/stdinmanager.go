@@ -0,0 +1,168 @@
+/*
+StdinManager starts shutdown when the input of the process reaches EOF,
+which is how a host process usually tells its plugin or pipeline stage
+to stop. It watches os.Stdin by default, or any configured io.Reader.
+
+The application can keep reading the input itself through Reader, which
+returns the data unchanged and starts shutdown once EOF is read.
+If the application does not read the input, set Discard and StdinManager
+will read and discard it in the background.
+
+EOF read before Start is remembered and starts shutdown from Start.
+
+StdinManager does not exit the process. Use GracefulShutdown.SetExitPolicy,
+so a plugin whose host closed its input exits once shutdown finishes:
+
+	gs.SetExitPolicy(&gracefulshutdown.ExitPolicy{})
+*/
+package stdinmanager
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+const Name = "StdinManager"
+
+// StdinManager implements ShutdownManager interface that is added
+// to GracefulShutdown. Initialize with NewStdinManager.
+type StdinManager struct {
+	config      *StdinManagerConfig
+	reader      *eofReader
+	discardOnce sync.Once
+
+	mutex   sync.Mutex
+	gs      gracefulshutdown.GSInterface
+	started bool
+	eof     bool
+	eofErr  error
+}
+
+// StdinManagerConfig provides configuration options for StdinManager.
+type StdinManagerConfig struct {
+	// Reader is the input to watch. Default is os.Stdin.
+	Reader io.Reader
+
+	// Discard makes StdinManager read and discard the input itself.
+	// Leave it false if the application reads the input through Reader.
+	Discard bool
+}
+
+func (smc *StdinManagerConfig) clean() {
+	if smc.Reader == nil {
+		smc.Reader = os.Stdin
+	}
+}
+
+// eofReader passes reads through and calls onEOF once when the
+// underlying reader returns an error.
+type eofReader struct {
+	reader io.Reader
+	once   sync.Once
+	onEOF  func(err error)
+}
+
+func (r *eofReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil {
+		r.once.Do(func() {
+			r.onEOF(err)
+		})
+	}
+	return n, err
+}
+
+// NewStdinManager initializes the StdinManager. See StdinManagerConfig for
+// configuration options.
+func NewStdinManager(stdinManagerConfig *StdinManagerConfig) *StdinManager {
+	if stdinManagerConfig == nil {
+		stdinManagerConfig = &StdinManagerConfig{}
+	}
+	stdinManagerConfig.clean()
+
+	stdinManager := &StdinManager{
+		config: stdinManagerConfig,
+	}
+	stdinManager.reader = &eofReader{
+		reader: stdinManagerConfig.Reader,
+		onEOF:  stdinManager.onEOF,
+	}
+	return stdinManager
+}
+
+// GetName returns name of this ShutdownManager.
+func (stdinManager *StdinManager) GetName() string {
+	return Name
+}
+
+// Start starts discarding the input if Discard is set. If EOF was
+// already read, shutdown is started.
+func (stdinManager *StdinManager) Start(gs gracefulshutdown.GSInterface) error {
+	stdinManager.mutex.Lock()
+	stdinManager.gs = gs
+	stdinManager.started = true
+	eof, err := stdinManager.eof, stdinManager.eofErr
+	stdinManager.mutex.Unlock()
+
+	if eof {
+		stdinManager.shutdown(gs, err)
+		return nil
+	}
+
+	if stdinManager.config.Discard {
+		stdinManager.discardOnce.Do(func() {
+			go io.Copy(ioutil.Discard, stdinManager.reader)
+		})
+	}
+
+	return nil
+}
+
+// Reader returns the watched input. Application should read the input
+// only through Reader, so StdinManager notices EOF.
+func (stdinManager *StdinManager) Reader() io.Reader {
+	return stdinManager.reader
+}
+
+func (stdinManager *StdinManager) onEOF(err error) {
+	stdinManager.mutex.Lock()
+	stdinManager.eof = true
+	stdinManager.eofErr = err
+	started, gs := stdinManager.started, stdinManager.gs
+	stdinManager.mutex.Unlock()
+
+	if started {
+		stdinManager.shutdown(gs, err)
+	}
+}
+
+func (stdinManager *StdinManager) shutdown(gs gracefulshutdown.GSInterface, err error) {
+	if err != io.EOF {
+		gs.ReportError(gracefulshutdown.NewError(Name, "read", gracefulshutdown.SeverityError, err))
+	}
+	go gs.StartShutdown(stdinManager)
+}
+
+// Stop stops watching the input. A read blocked on the input is not
+// interrupted, but its EOF no longer starts shutdown until Start is
+// called again.
+func (stdinManager *StdinManager) Stop() error {
+	stdinManager.mutex.Lock()
+	defer stdinManager.mutex.Unlock()
+	stdinManager.started = false
+	return nil
+}
+
+// ShutdownStart does nothing.
+func (stdinManager *StdinManager) ShutdownStart() error {
+	return nil
+}
+
+// ShutdownFinish does nothing.
+func (stdinManager *StdinManager) ShutdownFinish() error {
+	return nil
+}
@@ -0,0 +1,149 @@
+package stdinmanager
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+type gsMock struct {
+	shutdowns chan int
+	errors    chan error
+}
+
+func newGsMock() *gsMock {
+	return &gsMock{
+		shutdowns: make(chan int, 100),
+		errors:    make(chan error, 100),
+	}
+}
+
+func (gs *gsMock) StartShutdown(sm gracefulshutdown.ShutdownManager) {
+	gs.shutdowns <- 1
+}
+
+func (gs *gsMock) ReportError(err error) {
+	gs.errors <- err
+}
+
+func (gs *gsMock) AddShutdownCallback(shutdownCallback gracefulshutdown.ShutdownCallback) {
+
+}
+
+func waitShutdown(t *testing.T, c <-chan int) {
+	select {
+	case <-c:
+
+	case <-time.After(1 * time.Second):
+		t.Error("Timeout waiting for StartShutdown.")
+	}
+}
+
+func TestApplicationReads(t *testing.T) {
+	r, w := io.Pipe()
+	gs := newGsMock()
+
+	sm := NewStdinManager(&StdinManagerConfig{Reader: r})
+	sm.Start(gs)
+
+	go func() {
+		w.Write([]byte("line 1\nline 2\n"))
+		w.Close()
+	}()
+
+	lines := 0
+	scanner := bufio.NewScanner(sm.Reader())
+	for scanner.Scan() {
+		lines++
+	}
+
+	if lines != 2 {
+		t.Error("Expected application to read 2 lines, got", lines)
+	}
+
+	waitShutdown(t, gs.shutdowns)
+
+	if len(gs.errors) != 0 {
+		t.Error("EOF should not be reported as error.")
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	r, w := io.Pipe()
+	gs := newGsMock()
+
+	sm := NewStdinManager(&StdinManagerConfig{
+		Reader:  r,
+		Discard: true,
+	})
+	sm.Start(gs)
+
+	w.Write([]byte("ignored"))
+
+	time.Sleep(time.Millisecond * 5)
+	if len(gs.shutdowns) != 0 {
+		t.Error("Shutdown started before EOF.")
+	}
+
+	w.Close()
+
+	waitShutdown(t, gs.shutdowns)
+}
+
+func TestReadError(t *testing.T) {
+	r, w := io.Pipe()
+	gs := newGsMock()
+
+	sm := NewStdinManager(&StdinManagerConfig{
+		Reader:  r,
+		Discard: true,
+	})
+	sm.Start(gs)
+
+	w.CloseWithError(errors.New("my-error"))
+
+	waitShutdown(t, gs.shutdowns)
+
+	if err := <-gs.errors; err.Error() != "my-error" {
+		t.Error("Expected my-error, got", err)
+	}
+}
+
+func TestEOFBeforeStart(t *testing.T) {
+	gs := newGsMock()
+	sm := NewStdinManager(&StdinManagerConfig{
+		Reader: strings.NewReader("line\n"),
+	})
+
+	io.Copy(ioutil.Discard, sm.Reader())
+
+	sm.Start(gs)
+	waitShutdown(t, gs.shutdowns)
+}
+
+func TestStartAfterStop(t *testing.T) {
+	gs := newGsMock()
+	r, w := io.Pipe()
+	sm := NewStdinManager(&StdinManagerConfig{
+		Reader:  r,
+		Discard: true,
+	})
+
+	sm.Start(gs)
+	sm.Stop()
+	w.Close()
+
+	time.Sleep(time.Millisecond * 10)
+	if len(gs.shutdowns) != 0 {
+		t.Error("Shutdown started after Stop.")
+	}
+
+	sm.Start(gs)
+	waitShutdown(t, gs.shutdowns)
+}
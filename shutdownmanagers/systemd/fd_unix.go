@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package systemd
+
+import (
+	"syscall"
+)
+
+// closeOnExec marks a passed file descriptor, so it is not inherited by
+// child processes.
+func closeOnExec(fd int) {
+	syscall.CloseOnExec(fd)
+}
+
+// isStreamSocket returns true if fd is a stream socket that can be
+// used with net.FileListener.
+func isStreamSocket(fd int) bool {
+	sotype, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE)
+	return err == nil && sotype == syscall.SOCK_STREAM
+}
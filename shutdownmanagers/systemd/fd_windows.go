@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package systemd
+
+// closeOnExec does nothing, systemd does not pass file descriptors
+// on windows.
+func closeOnExec(fd int) {}
+
+// isStreamSocket returns false, systemd does not pass file descriptors
+// on windows.
+func isStreamSocket(fd int) bool {
+	return false
+}
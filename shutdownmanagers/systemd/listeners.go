@@ -0,0 +1,120 @@
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd
+// socket activation.
+var listenFdsStart = 3
+
+// Listeners returns listeners passed by systemd socket activation in the
+// order they are configured in the socket unit, and adds a ShutdownCallback
+// to gs that closes them when shutdown is requested, so they stop accepting
+// new connections. Returns no listeners if the process was not socket
+// activated.
+//
+// Only stream sockets are returned. Datagram sockets and FIFOs, like
+// ones from ListenDatagram= or ListenFIFO=, are skipped and left open,
+// so they do not make the whole call fail.
+//
+// LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES environment variables are unset,
+// so child processes do not inherit them.
+func Listeners(gs gracefulshutdown.GSInterface) ([]net.Listener, error) {
+	listeners, _, err := activationListeners(gs)
+	return listeners, err
+}
+
+// ListenersWithNames works like Listeners, but returns listeners grouped by
+// names from FileDescriptorName= option of the socket unit. Listeners
+// without a name are grouped under "unknown".
+func ListenersWithNames(gs gracefulshutdown.GSInterface) (map[string][]net.Listener, error) {
+	listeners, names, err := activationListeners(gs)
+	if err != nil {
+		return nil, err
+	}
+
+	named := make(map[string][]net.Listener)
+	for i, listener := range listeners {
+		named[names[i]] = append(named[names[i]], listener)
+	}
+	return named, nil
+}
+
+func activationListeners(gs gracefulshutdown.GSInterface) ([]net.Listener, []string, error) {
+	files := listenFiles()
+
+	listeners := make([]net.Listener, 0, len(files))
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, nil, fmt.Errorf("systemd listener %s: %v", file.Name(), err)
+		}
+		listeners = append(listeners, listener)
+		names = append(names, file.Name())
+	}
+
+	if len(listeners) > 0 {
+		gs.AddShutdownCallback(gracefulshutdown.ShutdownFunc(func(string) error {
+			return closeListeners(listeners)
+		}))
+	}
+
+	return listeners, names, nil
+}
+
+func listenFiles() []*os.File {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	files := make([]*os.File, 0, nfds)
+	for fd := listenFdsStart; fd < listenFdsStart+nfds; fd++ {
+		closeOnExec(fd)
+
+		if !isStreamSocket(fd) {
+			continue
+		}
+
+		name := "unknown"
+		if i := fd - listenFdsStart; i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files = append(files, os.NewFile(uintptr(fd), name))
+	}
+	return files
+}
+
+// closeListeners closes listeners, ignoring the ones already closed by
+// the application, like with http.Server.Shutdown.
+func closeListeners(listeners []net.Listener) error {
+	var err error
+	for _, listener := range listeners {
+		if e := listener.Close(); e != nil && !errors.Is(e, net.ErrClosed) && err == nil {
+			err = e
+		}
+	}
+	return err
+}
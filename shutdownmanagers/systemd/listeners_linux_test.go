@@ -0,0 +1,171 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// activationEnv sets up environment as if count listeners were passed
+// by systemd socket activation.
+func activationEnv(t *testing.T, names string, count int) []*net.TCPListener {
+	base := 200
+	tcpListeners := make([]*net.TCPListener, 0, count)
+	for i := 0; i < count; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := l.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := syscall.Dup3(int(f.Fd()), base+i, 0); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		tcpListeners = append(tcpListeners, l.(*net.TCPListener))
+	}
+
+	listenFdsStart = base
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", strconv.Itoa(count))
+	os.Setenv("LISTEN_FDNAMES", names)
+
+	return tcpListeners
+}
+
+func TestListenersNotActivated(t *testing.T) {
+	os.Unsetenv("LISTEN_PID")
+	gs := &gsMock{}
+
+	listeners, err := Listeners(gs)
+	if err != nil {
+		t.Error("Error getting listeners:", err)
+	}
+
+	if len(listeners) != 0 || len(gs.callbacks) != 0 {
+		t.Error("Expected no listeners and no callbacks.")
+	}
+}
+
+func TestListenersOtherPid(t *testing.T) {
+	os.Setenv("LISTEN_PID", "1")
+	os.Setenv("LISTEN_FDS", "1")
+
+	listeners, _ := Listeners(&gsMock{})
+	if len(listeners) != 0 {
+		t.Error("Listeners for other pid should be ignored.")
+	}
+
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("LISTEN_FDS should be unset.")
+	}
+}
+
+func TestListenersClosedOnShutdown(t *testing.T) {
+	originals := activationEnv(t, "", 1)
+	defer originals[0].Close()
+
+	gs := &gsMock{}
+	listeners, err := Listeners(gs)
+	if err != nil {
+		t.Fatal("Error getting listeners:", err)
+	}
+
+	if len(listeners) != 1 {
+		t.Fatal("Expected 1 listener, got", len(listeners))
+	}
+
+	if listeners[0].Addr().String() != originals[0].Addr().String() {
+		t.Error("Listener address does not match, got", listeners[0].Addr())
+	}
+
+	if len(gs.callbacks) != 1 {
+		t.Fatal("Expected 1 shutdown callback, got", len(gs.callbacks))
+	}
+
+	if err := gs.callbacks[0].OnShutdown("test-sm"); err != nil {
+		t.Error("Error closing listeners:", err)
+	}
+
+	if _, err := listeners[0].Accept(); err == nil {
+		t.Error("Listener should be closed after shutdown.")
+	}
+}
+
+func TestListenersAlreadyClosed(t *testing.T) {
+	originals := activationEnv(t, "", 1)
+	defer originals[0].Close()
+
+	gs := &gsMock{}
+	listeners, err := Listeners(gs)
+	if err != nil {
+		t.Fatal("Error getting listeners:", err)
+	}
+
+	// like http.Server.Shutdown in an application callback
+	listeners[0].Close()
+
+	if err := gs.callbacks[0].OnShutdown("test-sm"); err != nil {
+		t.Error("Expected no error for closed listener, got", err)
+	}
+}
+
+func TestListenersSkipDatagramSockets(t *testing.T) {
+	originals := activationEnv(t, "http:dns:admin", 2)
+	defer originals[0].Close()
+	defer originals[1].Close()
+
+	// move the second listener to make room for a datagram socket
+	// passed between them
+	if err := syscall.Dup3(listenFdsStart+1, listenFdsStart+2, 0); err != nil {
+		t.Fatal(err)
+	}
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	f, err := udp.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Dup3(int(f.Fd()), listenFdsStart+1, 0); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	os.Setenv("LISTEN_FDS", "3")
+
+	named, err := ListenersWithNames(&gsMock{})
+	if err != nil {
+		t.Fatal("Error getting listeners:", err)
+	}
+
+	if len(named["http"]) != 1 || len(named["admin"]) != 1 || len(named["dns"]) != 0 {
+		t.Error("Expected http and admin listeners, got", named)
+	}
+
+	syscall.Close(listenFdsStart + 1)
+}
+
+func TestListenersWithNames(t *testing.T) {
+	originals := activationEnv(t, "http:", 2)
+	defer originals[0].Close()
+	defer originals[1].Close()
+
+	named, err := ListenersWithNames(&gsMock{})
+	if err != nil {
+		t.Fatal("Error getting listeners:", err)
+	}
+
+	if len(named["http"]) != 1 || named["http"][0].Addr().String() != originals[0].Addr().String() {
+		t.Error("Expected http listener, got", named["http"])
+	}
+
+	if len(named["unknown"]) != 1 || named["unknown"][0].Addr().String() != originals[1].Addr().String() {
+		t.Error("Expected unknown listener, got", named["unknown"])
+	}
+}
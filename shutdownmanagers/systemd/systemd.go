@@ -0,0 +1,254 @@
+/*
+SystemdManager provides integration with the systemd service manager
+via the sd_notify protocol. On Start it starts sending WATCHDOG=1, if
+systemd requested it, until the process exits. READY=1 is sent only when
+the application calls Ready, after it finished initializing, so a
+Type=notify unit is not reported ready too early.
+When shutdown is started by any ShutdownManager it sends STOPPING=1 and
+then periodically sends EXTEND_TIMEOUT_USEC, so systemd does not kill
+the process while callbacks are still running. Extending stops when
+shutdown finishes or after MaxExtend, so a hanging shutdown is still
+killed by systemd.
+If the process was not started by systemd, SystemdManager does nothing.
+
+Listeners and ListenersWithNames return listeners passed by systemd
+socket activation and close them when shutdown is requested.
+*/
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+const (
+	Name = "SystemdManager"
+
+	defaultExtendTimeout = time.Second * 30
+	defaultMaxExtend     = time.Minute * 5
+)
+
+// SystemdManager implements ShutdownManager interface that is added
+// to GracefulShutdown. Initialize with NewSystemdManager.
+type SystemdManager struct {
+	gs     gracefulshutdown.GSInterface
+	config *SystemdManagerConfig
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mutex    sync.Mutex
+	stopping bool
+}
+
+// SystemdManagerConfig provides configuration options for SystemdManager.
+type SystemdManagerConfig struct {
+	// Socket is the path of the notification socket. If empty, it is
+	// read from NOTIFY_SOCKET environment variable.
+	Socket string
+
+	// WatchdogInterval is period for sending WATCHDOG=1. If 0, it is set
+	// to half of WATCHDOG_USEC environment variable, if the watchdog is
+	// enabled for this process.
+	WatchdogInterval time.Duration
+
+	// ExtendTimeout is the time requested with every EXTEND_TIMEOUT_USEC
+	// during shutdown. It is sent every half of ExtendTimeout.
+	// Default is 30 seconds.
+	ExtendTimeout time.Duration
+
+	// MaxExtend is the total time after STOPPING=1 during which stop
+	// timeout is extended. Default is 5 minutes.
+	MaxExtend time.Duration
+}
+
+func (smc *SystemdManagerConfig) clean() {
+	if smc.Socket == "" {
+		smc.Socket = os.Getenv("NOTIFY_SOCKET")
+	}
+	if smc.WatchdogInterval == 0 {
+		smc.WatchdogInterval = watchdogInterval()
+	}
+	if smc.ExtendTimeout == 0 {
+		smc.ExtendTimeout = defaultExtendTimeout
+	}
+	if smc.MaxExtend == 0 {
+		smc.MaxExtend = defaultMaxExtend
+	}
+}
+
+// watchdogInterval returns half of WATCHDOG_USEC, or 0 if watchdog
+// is not enabled for this process.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// NewSystemdManager initializes the SystemdManager. See SystemdManagerConfig for
+// configuration options.
+func NewSystemdManager(systemdManagerConfig *SystemdManagerConfig) *SystemdManager {
+	if systemdManagerConfig == nil {
+		systemdManagerConfig = &SystemdManagerConfig{}
+	}
+	systemdManagerConfig.clean()
+	return &SystemdManager{
+		config: systemdManagerConfig,
+	}
+}
+
+// GetName returns name of this ShutdownManager.
+func (systemdManager *SystemdManager) GetName() string {
+	return Name
+}
+
+// Start starts sending watchdog pings. Errors sending them are reported
+// to ErrorHandlers.
+func (systemdManager *SystemdManager) Start(gs gracefulshutdown.GSInterface) error {
+	if systemdManager.config.Socket == "" {
+		return nil
+	}
+
+	gs.AddShutdownCallback(systemdManager)
+
+	stop := make(chan struct{})
+	systemdManager.mutex.Lock()
+	systemdManager.gs = gs
+	systemdManager.stop = stop
+	systemdManager.stopOnce = sync.Once{}
+	systemdManager.mutex.Unlock()
+
+	if systemdManager.config.WatchdogInterval > 0 {
+		go systemdManager.watchdog(gs, stop)
+	}
+
+	return nil
+}
+
+// Ready sends READY=1 to systemd. Call it once GracefulShutdown.Start
+// returned and the application is ready to serve, like after opening
+// its listeners. Does nothing if the process was not started by systemd.
+func (systemdManager *SystemdManager) Ready() error {
+	return systemdManager.Notify("READY=1")
+}
+
+// Notify sends state to systemd notification socket. See sd_notify(3)
+// for valid states. Does nothing if the process was not started by systemd.
+func (systemdManager *SystemdManager) Notify(state string) error {
+	if systemdManager.config.Socket == "" {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{
+		Name: systemdManager.config.Socket,
+		Net:  "unixgram",
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// OnShutdown sends STOPPING=1 and starts extending systemd stop timeout
+// until shutdown finishes, MaxExtend passes or Stop is called.
+func (systemdManager *SystemdManager) OnShutdown(shutdownManager string) error {
+	systemdManager.mutex.Lock()
+	defer systemdManager.mutex.Unlock()
+
+	if systemdManager.stopping {
+		return nil
+	}
+	systemdManager.stopping = true
+
+	if err := systemdManager.Notify("STOPPING=1"); err != nil {
+		return err
+	}
+
+	go systemdManager.extendTimeout(systemdManager.gs, systemdManager.stop)
+
+	return nil
+}
+
+func (systemdManager *SystemdManager) watchdog(gs gracefulshutdown.GSInterface, stop <-chan struct{}) {
+	ticker := time.NewTicker(systemdManager.config.WatchdogInterval)
+	defer ticker.Stop()
+
+	for {
+		gs.ReportError(gracefulshutdown.NewError(Name, "watchdog", gracefulshutdown.SeverityError, systemdManager.Notify("WATCHDOG=1")))
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (systemdManager *SystemdManager) extendTimeout(gs gracefulshutdown.GSInterface, stop <-chan struct{}) {
+	ticker := time.NewTicker(systemdManager.config.ExtendTimeout / 2)
+	defer ticker.Stop()
+
+	deadline := time.NewTimer(systemdManager.config.MaxExtend)
+	defer deadline.Stop()
+
+	// done stays nil and blocks if GSInterface can not tell when
+	// shutdown finishes
+	var done <-chan struct{}
+	if doneNotifier, ok := gs.(gracefulshutdown.DoneNotifier); ok {
+		done = doneNotifier.Done()
+	}
+
+	state := fmt.Sprintf("EXTEND_TIMEOUT_USEC=%d", systemdManager.config.ExtendTimeout/time.Microsecond)
+	for {
+		gs.ReportError(gracefulshutdown.NewError(Name, "extend timeout", gracefulshutdown.SeverityError, systemdManager.Notify(state)))
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		case <-deadline.C:
+			return
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Stop stops sending watchdog pings and extending stop timeout.
+func (systemdManager *SystemdManager) Stop() error {
+	systemdManager.mutex.Lock()
+	defer systemdManager.mutex.Unlock()
+
+	systemdManager.stopOnce.Do(func() {
+		if systemdManager.stop != nil {
+			close(systemdManager.stop)
+		}
+	})
+	return nil
+}
+
+// ShutdownStart does nothing.
+func (systemdManager *SystemdManager) ShutdownStart() error {
+	return nil
+}
+
+// ShutdownFinish does nothing.
+func (systemdManager *SystemdManager) ShutdownFinish() error {
+	return nil
+}
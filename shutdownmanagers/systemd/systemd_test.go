@@ -0,0 +1,271 @@
+package systemd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/internal/testutil"
+)
+
+type gsMock struct {
+	callbacks []gracefulshutdown.ShutdownCallback
+	done      chan struct{}
+}
+
+func (gs *gsMock) Done() <-chan struct{} {
+	return gs.done
+}
+
+func (gs *gsMock) StartShutdown(sm gracefulshutdown.ShutdownManager) {
+
+}
+
+func (gs *gsMock) ReportError(err error) {
+
+}
+
+func (gs *gsMock) AddShutdownCallback(shutdownCallback gracefulshutdown.ShutdownCallback) {
+	gs.callbacks = append(gs.callbacks, shutdownCallback)
+}
+
+func listenNotify(t *testing.T) (*net.UnixConn, string, func()) {
+	dir, err := ioutil.TempDir("", "systemd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return conn, socket, func() {
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal("Timeout waiting for notification:", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNoSocket(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	gs := &gsMock{}
+
+	sm := NewSystemdManager(nil)
+	if err := sm.Start(gs); err != nil {
+		t.Error("Start should not fail without socket, got", err)
+	}
+
+	if len(gs.callbacks) != 0 {
+		t.Error("Callback should not be added without socket.")
+	}
+}
+
+func TestReady(t *testing.T) {
+	conn, socket, cleanup := listenNotify(t)
+	defer cleanup()
+
+	sm := NewSystemdManager(&SystemdManagerConfig{
+		Socket: socket,
+	})
+	if err := sm.Start(&gsMock{}); err != nil {
+		t.Fatal("Error in start:", err)
+	}
+	expectNoNotify(t, conn)
+
+	if err := sm.Ready(); err != nil {
+		t.Error("Error in ready:", err)
+	}
+	if state := readNotify(t, conn); state != "READY=1" {
+		t.Error("Expected READY=1, got", state)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	conn, socket, cleanup := listenNotify(t)
+	defer cleanup()
+
+	sm := NewSystemdManager(&SystemdManagerConfig{
+		Socket:           socket,
+		WatchdogInterval: time.Millisecond * 5,
+	})
+	sm.Start(&gsMock{})
+
+	for i := 0; i < 3; i++ {
+		if state := readNotify(t, conn); state != "WATCHDOG=1" {
+			t.Error("Expected WATCHDOG=1, got", state)
+		}
+	}
+}
+
+func TestWatchdogFromEnv(t *testing.T) {
+	os.Setenv("WATCHDOG_USEC", "4000000")
+	os.Unsetenv("WATCHDOG_PID")
+	defer os.Unsetenv("WATCHDOG_USEC")
+
+	sm := NewSystemdManager(nil)
+	if sm.config.WatchdogInterval != time.Second*2 {
+		t.Error("Expected watchdog interval 2s, got", sm.config.WatchdogInterval)
+	}
+
+	os.Setenv("WATCHDOG_PID", "1")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	sm = NewSystemdManager(nil)
+	if sm.config.WatchdogInterval != 0 {
+		t.Error("Watchdog should be disabled for other pid, got", sm.config.WatchdogInterval)
+	}
+}
+
+func TestStoppingAndExtendTimeout(t *testing.T) {
+	conn, socket, cleanup := listenNotify(t)
+	defer cleanup()
+
+	gs := &gsMock{}
+	sm := NewSystemdManager(&SystemdManagerConfig{
+		Socket:        socket,
+		ExtendTimeout: time.Millisecond * 10,
+	})
+	sm.Start(gs)
+
+	if len(gs.callbacks) != 1 {
+		t.Fatal("Expected 1 shutdown callback, got", len(gs.callbacks))
+	}
+
+	if err := gs.callbacks[0].OnShutdown("test-sm"); err != nil {
+		t.Error("Error in OnShutdown:", err)
+	}
+	gs.callbacks[0].OnShutdown("test-sm")
+
+	if state := readNotify(t, conn); state != "STOPPING=1" {
+		t.Error("Expected STOPPING=1, got", state)
+	}
+
+	for i := 0; i < 2; i++ {
+		if state := readNotify(t, conn); state != "EXTEND_TIMEOUT_USEC=10000" {
+			t.Error("Expected EXTEND_TIMEOUT_USEC=10000, got", state)
+		}
+	}
+}
+
+func expectNoNotify(t *testing.T, conn *net.UnixConn) {
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond * 50))
+	if n, err := conn.Read(buf); err == nil {
+		t.Error("Expected no notification, got", string(buf[:n]))
+	}
+}
+
+func drainNotify(conn *net.UnixConn, wait time.Duration) {
+	buf := make([]byte, 1024)
+	for {
+		conn.SetReadDeadline(time.Now().Add(wait))
+		if _, err := conn.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+func TestExtendTimeoutStopsWhenDone(t *testing.T) {
+	conn, socket, cleanup := listenNotify(t)
+	defer cleanup()
+
+	gs := &gsMock{done: make(chan struct{})}
+	sm := NewSystemdManager(&SystemdManagerConfig{
+		Socket:        socket,
+		ExtendTimeout: time.Millisecond * 10,
+	})
+	sm.Start(gs)
+
+	gs.callbacks[0].OnShutdown("test-sm")
+	readNotify(t, conn)
+	readNotify(t, conn)
+
+	close(gs.done)
+	drainNotify(conn, time.Millisecond*20)
+	expectNoNotify(t, conn)
+}
+
+func TestExtendTimeoutStopsAfterMaxExtend(t *testing.T) {
+	conn, socket, cleanup := listenNotify(t)
+	defer cleanup()
+
+	gs := &gsMock{}
+	sm := NewSystemdManager(&SystemdManagerConfig{
+		Socket:        socket,
+		ExtendTimeout: time.Millisecond * 10,
+		MaxExtend:     time.Millisecond * 30,
+	})
+	sm.Start(gs)
+
+	gs.callbacks[0].OnShutdown("test-sm")
+	readNotify(t, conn)
+
+	time.Sleep(time.Millisecond * 40)
+	drainNotify(conn, time.Millisecond*20)
+	expectNoNotify(t, conn)
+}
+
+func TestNotifyError(t *testing.T) {
+	sm := NewSystemdManager(&SystemdManagerConfig{
+		Socket: filepath.Join(os.TempDir(), "does-not-exist", "notify"),
+	})
+
+	if err := sm.Start(&gsMock{}); err != nil {
+		t.Error("Error in start:", err)
+	}
+
+	err := sm.Ready()
+	if err == nil || !strings.Contains(err.Error(), "does-not-exist") {
+		t.Error("Expected error for missing socket, got", err)
+	}
+}
+
+func TestStop(t *testing.T) {
+	conn, socket, cleanup := listenNotify(t)
+	defer cleanup()
+
+	goroutines := testutil.Goroutines()
+
+	sm := NewSystemdManager(&SystemdManagerConfig{
+		Socket:           socket,
+		WatchdogInterval: time.Millisecond * 5,
+	})
+	sm.Start(&gsMock{})
+
+	readNotify(t, conn)
+	readNotify(t, conn)
+
+	if err := sm.Stop(); err != nil {
+		t.Error("Error in stop:", err)
+	}
+	sm.Stop()
+	testutil.WaitGoroutines(t, goroutines)
+
+	drainNotify(conn, time.Millisecond*10)
+	expectNoNotify(t, conn)
+
+	// Start after Stop sends watchdog pings again
+	sm.Start(&gsMock{})
+	defer sm.Stop()
+
+	if state := readNotify(t, conn); state != "WATCHDOG=1" {
+		t.Error("Expected WATCHDOG=1 after restart, got", state)
+	}
+}
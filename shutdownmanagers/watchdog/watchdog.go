@@ -0,0 +1,196 @@
+/*
+WatchdogManager starts shutdown when the application stops calling Kick
+for longer than Timeout, which usually means a main loop is stuck.
+Before shutdown starts, stacks of all goroutines are reported to the
+ErrorHandler to help find the deadlock.
+
+If shutdown started by WatchdogManager does not finish in
+HardExitTimeout, the process exits immediately with ExitCode. Otherwise
+WatchdogManager does not exit the process. Use
+GracefulShutdown.SetExitPolicy to exit when shutdown finishes, as a
+stuck process can not recover.
+*/
+package watchdog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+const (
+	Name = "WatchdogManager"
+
+	defaultHardExitTimeout = time.Minute
+	defaultExitCode        = 1
+	maxStackSize           = 1 << 20
+)
+
+// ErrNoTimeout is returned from Start if Timeout is not set.
+var ErrNoTimeout = errors.New("watchdog: Timeout is required")
+
+// exit is replaced in tests.
+var exit = os.Exit
+
+// WatchdogManager implements ShutdownManager interface that is added
+// to GracefulShutdown. Initialize with NewWatchdogManager.
+type WatchdogManager struct {
+	gs     gracefulshutdown.GSInterface
+	config *WatchdogManagerConfig
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mutex    sync.Mutex
+	lastKick time.Time
+}
+
+// WatchdogManagerConfig provides configuration options for WatchdogManager.
+type WatchdogManagerConfig struct {
+	// Timeout is maximum time between two calls to Kick.
+	Timeout time.Duration
+
+	// CheckInterval is period for checking time since last Kick.
+	// Default is a tenth of Timeout.
+	CheckInterval time.Duration
+
+	// HardExitTimeout is time shutdown can take before the process
+	// exits immediately. Default is 1 minute.
+	HardExitTimeout time.Duration
+
+	// ExitCode is exit code of the process when HardExitTimeout expires.
+	// Default is 1.
+	ExitCode int
+}
+
+func (wmc *WatchdogManagerConfig) clean() {
+	if wmc.CheckInterval == 0 {
+		wmc.CheckInterval = wmc.Timeout / 10
+	}
+	if wmc.HardExitTimeout == 0 {
+		wmc.HardExitTimeout = defaultHardExitTimeout
+	}
+	if wmc.ExitCode == 0 {
+		wmc.ExitCode = defaultExitCode
+	}
+}
+
+// NewWatchdogManager initializes the WatchdogManager. See
+// WatchdogManagerConfig for configuration options.
+func NewWatchdogManager(watchdogManagerConfig *WatchdogManagerConfig) *WatchdogManager {
+	if watchdogManagerConfig == nil {
+		watchdogManagerConfig = &WatchdogManagerConfig{}
+	}
+	watchdogManagerConfig.clean()
+	return &WatchdogManager{
+		config:   watchdogManagerConfig,
+		lastKick: time.Now(),
+	}
+}
+
+// GetName returns name of this ShutdownManager.
+func (watchdogManager *WatchdogManager) GetName() string {
+	return Name
+}
+
+// Start starts watching for kicks. Will return error if Timeout is not set.
+func (watchdogManager *WatchdogManager) Start(gs gracefulshutdown.GSInterface) error {
+	watchdogManager.gs = gs
+
+	if watchdogManager.config.Timeout <= 0 {
+		return ErrNoTimeout
+	}
+
+	watchdogManager.Kick()
+
+	stop := make(chan struct{})
+	watchdogManager.stop = stop
+	watchdogManager.stopOnce = sync.Once{}
+	go watchdogManager.watch(stop)
+
+	return nil
+}
+
+// Kick tells the watchdog the application is still running.
+func (watchdogManager *WatchdogManager) Kick() {
+	watchdogManager.mutex.Lock()
+	watchdogManager.lastKick = time.Now()
+	watchdogManager.mutex.Unlock()
+}
+
+func (watchdogManager *WatchdogManager) sinceKick() time.Duration {
+	watchdogManager.mutex.Lock()
+	defer watchdogManager.mutex.Unlock()
+	return time.Since(watchdogManager.lastKick)
+}
+
+func (watchdogManager *WatchdogManager) watch(stop <-chan struct{}) {
+	ticker := time.NewTicker(watchdogManager.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		since := watchdogManager.sinceKick()
+		if since < watchdogManager.config.Timeout {
+			continue
+		}
+
+		watchdogManager.gs.ReportError(gracefulshutdown.NewError(Name, "timeout", gracefulshutdown.SeverityCritical, fmt.Errorf("watchdog: not kicked for %s, goroutines:\n%s", since, stacks())))
+
+		done := make(chan struct{})
+		go func() {
+			watchdogManager.gs.StartShutdown(watchdogManager)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(watchdogManager.config.HardExitTimeout):
+			watchdogManager.gs.ReportError(gracefulshutdown.NewError(Name, "hard exit", gracefulshutdown.SeverityCritical, fmt.Errorf("watchdog: shutdown did not finish in %s, exiting", watchdogManager.config.HardExitTimeout)))
+			exit(watchdogManager.config.ExitCode)
+		}
+		return
+	}
+}
+
+// stacks returns stack traces of all goroutines.
+func stacks() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxStackSize {
+			return buf[:n]
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+}
+
+// Stop stops watching for kicks.
+func (watchdogManager *WatchdogManager) Stop() error {
+	watchdogManager.stopOnce.Do(func() {
+		if watchdogManager.stop != nil {
+			close(watchdogManager.stop)
+		}
+	})
+	return nil
+}
+
+// ShutdownStart does nothing.
+func (watchdogManager *WatchdogManager) ShutdownStart() error {
+	return nil
+}
+
+// ShutdownFinish does nothing.
+func (watchdogManager *WatchdogManager) ShutdownFinish() error {
+	return nil
+}
@@ -0,0 +1,149 @@
+package watchdog
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zemanta/gracefulshutdown"
+	"github.com/Zemanta/gracefulshutdown/internal/testutil"
+)
+
+type gsMock struct {
+	shutdowns chan int
+	errors    chan error
+	block     chan struct{}
+}
+
+func newGsMock() *gsMock {
+	return &gsMock{
+		shutdowns: make(chan int, 100),
+		errors:    make(chan error, 100),
+	}
+}
+
+func (gs *gsMock) StartShutdown(sm gracefulshutdown.ShutdownManager) {
+	gs.shutdowns <- 1
+	if gs.block != nil {
+		<-gs.block
+	}
+}
+
+func (gs *gsMock) ReportError(err error) {
+	if err != nil {
+		gs.errors <- err
+	}
+}
+
+func (gs *gsMock) AddShutdownCallback(shutdownCallback gracefulshutdown.ShutdownCallback) {
+
+}
+
+func mockExit(t *testing.T) chan int {
+	codes := make(chan int, 100)
+	exit = func(code int) {
+		codes <- code
+	}
+	t.Cleanup(func() {
+		exit = os.Exit
+	})
+	return codes
+}
+
+func TestNoTimeout(t *testing.T) {
+	wm := NewWatchdogManager(nil)
+	if err := wm.Start(newGsMock()); err != ErrNoTimeout {
+		t.Error("Expected ErrNoTimeout, got", err)
+	}
+}
+
+func TestKick(t *testing.T) {
+	gs := newGsMock()
+	wm := NewWatchdogManager(&WatchdogManagerConfig{
+		Timeout:       time.Millisecond * 20,
+		CheckInterval: time.Millisecond,
+	})
+	wm.Start(gs)
+
+	for i := 0; i < 10; i++ {
+		time.Sleep(time.Millisecond * 5)
+		wm.Kick()
+	}
+
+	if len(gs.shutdowns) != 0 {
+		t.Error("Shutdown started while kicking.")
+	}
+
+	select {
+	case <-gs.shutdowns:
+
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for StartShutdown.")
+	}
+
+	err := <-gs.errors
+	if !strings.Contains(err.Error(), "not kicked") || !strings.Contains(err.Error(), "goroutine ") {
+		t.Error("Expected error with goroutine stacks, got", err)
+	}
+}
+
+func TestHardExit(t *testing.T) {
+	codes := mockExit(t)
+	gs := newGsMock()
+	gs.block = make(chan struct{})
+	defer close(gs.block)
+
+	wm := NewWatchdogManager(&WatchdogManagerConfig{
+		Timeout:         time.Millisecond * 5,
+		CheckInterval:   time.Millisecond,
+		HardExitTimeout: time.Millisecond * 20,
+		ExitCode:        3,
+	})
+	wm.Start(gs)
+
+	select {
+	case code := <-codes:
+		if code != 3 {
+			t.Error("Expected exit code 3, got", code)
+		}
+
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for exit.")
+	}
+
+	if len(gs.errors) != 2 {
+		t.Error("Expected 2 reported errors, got", len(gs.errors))
+	}
+}
+
+func TestStop(t *testing.T) {
+	goroutines := testutil.Goroutines()
+
+	gs := newGsMock()
+	wm := NewWatchdogManager(&WatchdogManagerConfig{
+		Timeout:       time.Millisecond * 20,
+		CheckInterval: time.Millisecond,
+	})
+	wm.Start(gs)
+
+	if err := wm.Stop(); err != nil {
+		t.Error("Error in stop:", err)
+	}
+	wm.Stop()
+	testutil.WaitGoroutines(t, goroutines)
+
+	time.Sleep(time.Millisecond * 40)
+	if len(gs.shutdowns) != 0 {
+		t.Error("Shutdown started after Stop.")
+	}
+
+	wm.Start(gs)
+	defer wm.Stop()
+
+	select {
+	case <-gs.shutdowns:
+	case <-time.After(time.Second):
+		t.Error("Expected shutdown after restart.")
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Package sloghandler logs errors and shutdown events with log/slog.
+Handler implements both ErrorHandler and EventHandler:
+
+	handler := sloghandler.New(slog.Default())
+	gs.SetErrorHandler(handler)
+	gs.SetEventHandler(handler)
+
+Every record has the attribute "manager" with the name of the
+ShutdownManager that requested shutdown or reported the event. Phase and
+callback records add "phase" and "callback", finished ones "duration",
+and failed ones "error" with level Warn. Events of ShutdownManagers, like
+AwsManager heartbeats, add "action" and their own fields.
+
+Errors reported to ErrorHandler are logged with "error" and, for
+gracefulshutdown.Error, with "source", "phase", "action" and "severity".
+The level is Warn for SeverityWarning, Error for SeverityError and
+LevelCritical for SeverityCritical.
+*/
+package sloghandler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+// LevelCritical is the level of errors with SeverityCritical.
+const LevelCritical = slog.LevelError + 4
+
+var messages = map[gracefulshutdown.EventType]string{
+	gracefulshutdown.EventShutdownRequested: "shutdown requested",
+	gracefulshutdown.EventPhaseStarted:      "shutdown phase started",
+	gracefulshutdown.EventPhaseFinished:     "shutdown phase finished",
+	gracefulshutdown.EventCallbackStarted:   "shutdown callback started",
+	gracefulshutdown.EventCallbackFinished:  "shutdown callback finished",
+	gracefulshutdown.EventManager:           "shutdown manager action",
+}
+
+// Handler implements ErrorHandler and EventHandler interfaces.
+// Initialize with New.
+type Handler struct {
+	logger *slog.Logger
+}
+
+// New initializes the Handler. If logger is nil, slog.Default is used.
+func New(logger *slog.Logger) *Handler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Handler{
+		logger: logger,
+	}
+}
+
+// OnError logs the error.
+func (handler *Handler) OnError(err error) {
+	level := slog.LevelError
+	var attrs []slog.Attr
+
+	var gsErr *gracefulshutdown.Error
+	if errors.As(err, &gsErr) {
+		attrs = append(attrs, slog.String("source", gsErr.Source))
+		if gsErr.Phase != "" {
+			attrs = append(attrs, slog.String("phase", string(gsErr.Phase)))
+		}
+		if gsErr.Action != "" {
+			attrs = append(attrs, slog.String("action", gsErr.Action))
+		}
+		attrs = append(attrs, slog.String("severity", gsErr.Severity.String()))
+
+		switch gsErr.Severity {
+		case gracefulshutdown.SeverityWarning:
+			level = slog.LevelWarn
+		case gracefulshutdown.SeverityCritical:
+			level = LevelCritical
+		}
+	}
+	attrs = append(attrs, slog.Any("error", err))
+
+	handler.logger.LogAttrs(context.Background(), level, "shutdown error", attrs...)
+}
+
+// OnEvent logs the event.
+func (handler *Handler) OnEvent(event gracefulshutdown.Event) {
+	level := slog.LevelInfo
+	if event.Err != nil {
+		level = slog.LevelWarn
+	}
+
+	message, ok := messages[event.Type]
+	if !ok {
+		message = string(event.Type)
+	}
+
+	handler.logger.LogAttrs(context.Background(), level, message, Attrs(event)...)
+}
+
+// Attrs returns slog attributes of the event, for use in custom handlers.
+func Attrs(event gracefulshutdown.Event) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("manager", event.Manager),
+	}
+	if event.Phase != "" {
+		attrs = append(attrs, slog.String("phase", string(event.Phase)))
+	}
+	if event.Callback != "" {
+		attrs = append(attrs, slog.String("callback", event.Callback))
+	}
+	if event.Action != "" {
+		attrs = append(attrs, slog.String("action", event.Action))
+	}
+	if event.Type == gracefulshutdown.EventPhaseFinished ||
+		event.Type == gracefulshutdown.EventCallbackFinished ||
+		event.Type == gracefulshutdown.EventManager {
+		attrs = append(attrs, slog.Duration("duration", event.Duration))
+	}
+
+	keys := make([]string, 0, len(event.Fields))
+	for key := range event.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		attrs = append(attrs, slog.Any(key, event.Fields[key]))
+	}
+
+	if event.Err != nil {
+		attrs = append(attrs, slog.Any("error", event.Err))
+	}
+	return attrs
+}
@@ -0,0 +1,123 @@
+package sloghandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/Zemanta/gracefulshutdown"
+)
+
+type smMock struct{}
+
+func (sm smMock) GetName() string {
+	return "test-sm"
+}
+
+func (sm smMock) Start(gs gracefulshutdown.GSInterface) error {
+	return nil
+}
+
+func (sm smMock) ShutdownStart() error {
+	return nil
+}
+
+func (sm smMock) ShutdownFinish() error {
+	return nil
+}
+
+func TestShutdownLogged(t *testing.T) {
+	var buf bytes.Buffer
+	handler := New(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	gs := gracefulshutdown.New()
+	gs.SetErrorHandler(handler)
+	gs.SetEventHandler(handler)
+	gs.AddShutdownCallback(gracefulshutdown.ShutdownFunc(func(string) error {
+		return errors.New("my-error")
+	}))
+
+	gs.StartShutdown(smMock{})
+
+	var records []map[string]interface{}
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		record := make(map[string]interface{})
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatal("Decode:", err)
+		}
+		records = append(records, record)
+	}
+
+	if len(records) != 10 {
+		t.Fatal("Expected 10 records, got", len(records))
+	}
+
+	if records[0]["msg"] != "shutdown requested" || records[0]["manager"] != "test-sm" {
+		t.Error("Unexpected first record", records[0])
+	}
+
+	var callback, shutdownError map[string]interface{}
+	for _, record := range records {
+		switch record["msg"] {
+		case "shutdown callback finished":
+			callback = record
+		case "shutdown error":
+			shutdownError = record
+		}
+	}
+
+	if callback["level"] != "WARN" || callback["error"] != "my-error" || callback["callback"] != "gracefulshutdown.ShutdownFunc#0" || callback["phase"] != "Callbacks" {
+		t.Error("Unexpected callback record", callback)
+	}
+	if _, ok := callback["duration"]; !ok {
+		t.Error("Expected duration in callback record", callback)
+	}
+	if shutdownError["level"] != "ERROR" || shutdownError["error"] != "my-error" || shutdownError["source"] != "gracefulshutdown.ShutdownFunc#0" || shutdownError["severity"] != "error" {
+		t.Error("Unexpected error record", shutdownError)
+	}
+}
+
+func TestManagerEvent(t *testing.T) {
+	attrs := Attrs(gracefulshutdown.Event{
+		Type:    gracefulshutdown.EventManager,
+		Manager: "AwsManager",
+		Action:  "forward",
+		Fields: map[string]interface{}{
+			"instanceId":           "i-1",
+			"autoScalingGroupName": "asg",
+		},
+	})
+
+	var keys []string
+	for _, attr := range attrs {
+		keys = append(keys, attr.Key)
+	}
+
+	expected := []string{"manager", "action", "duration", "autoScalingGroupName", "instanceId"}
+	if len(keys) != len(expected) {
+		t.Fatal("Expected", expected, "got", keys)
+	}
+	for i := range expected {
+		if keys[i] != expected[i] {
+			t.Error("Expected", expected, "got", keys)
+		}
+	}
+}
+
+func TestErrorSeverity(t *testing.T) {
+	var buf bytes.Buffer
+	handler := New(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	handler.OnError(gracefulshutdown.NewError("AwsManager", "receive", gracefulshutdown.SeverityWarning, errors.New("unreachable")))
+
+	record := make(map[string]interface{})
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal("Unmarshal:", err)
+	}
+	if record["level"] != "WARN" || record["source"] != "AwsManager" || record["action"] != "receive" || record["error"] != "unreachable" {
+		t.Error("Unexpected record", record)
+	}
+}